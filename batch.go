@@ -0,0 +1,186 @@
+package cmap
+
+import "sync"
+
+// BatchOption configures MGet, MSet and MRemove.
+type BatchOption func(*batchOptions)
+
+type batchOptions struct {
+	parallelism int
+}
+
+// WithParallelism runs the shard groups of a batch on up to n goroutines.
+// By default they run one after the other on the calling goroutine.
+func WithParallelism(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.parallelism = n
+	}
+}
+
+// batch groups the positions 0 to n-1 of a batch by the shard of keyAt(i),
+// then calls fn once per shard with the shard locked, for writing if write.
+// The shards are pinned, so the groups stay valid while Resize waits.
+func (m *Map[K, V]) batch(n int, keyAt func(i int) K, write bool, opts []BatchOption, fn func(shard *Shard[K, V], group []int)) {
+	o := batchOptions{parallelism: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	shards := m.pinShards()
+	defer m.unpinShards()
+	// Sort the positions by shard, counting the size of each group first.
+	slot := func(hash uint32) int {
+		return int(uint(hash) % uint(len(shards)))
+	}
+	if !m.settled(shards) {
+		// Resize is moving entries, find the shards the long way.
+		slotOf := make(map[*Shard[K, V]]int, len(shards))
+		for i, shard := range shards {
+			slotOf[shard] = i
+		}
+		slot = func(hash uint32) int {
+			return slotOf[m.shardFor(hash)]
+		}
+	}
+	slots := make([]int32, n)
+	start := make([]int, len(shards)+1)
+	for i := range slots {
+		slots[i] = int32(slot(m.sharding(keyAt(i))))
+		start[slots[i]+1]++
+	}
+	for i := 1; i < len(start); i++ {
+		start[i] += start[i-1]
+	}
+	order := make([]int, n)
+	next := append([]int(nil), start...)
+	for i, slot := range slots {
+		order[next[slot]] = i
+		next[slot]++
+	}
+	type group struct {
+		shard *Shard[K, V]
+		pos   []int
+	}
+	groups := make([]group, 0, len(shards))
+	for i, shard := range shards {
+		if start[i] < start[i+1] {
+			groups = append(groups, group{shard, order[start[i]:start[i+1]]})
+		}
+	}
+	run := func(shard *Shard[K, V], group []int) {
+		if write {
+			shard.Lock()
+			fn(shard, group)
+			m.unlock(shard)
+		} else {
+			shard.RLock()
+			fn(shard, group)
+			shard.RUnlock()
+		}
+	}
+	if o.parallelism <= 1 || len(groups) == 1 {
+		for _, g := range groups {
+			run(g.shard, g.pos)
+		}
+		return
+	}
+	var (
+		wg      sync.WaitGroup
+		carrier panicCarrier
+	)
+	sem := make(chan struct{}, o.parallelism)
+	for _, g := range groups {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			carrier.run(func() { run(g.shard, g.pos) })
+		}()
+	}
+	wg.Wait()
+	carrier.rethrow()
+}
+
+// panicCarrier raises on the calling goroutine the first panic of the
+// functions it ran on others, such as a write the write-ahead log failed.
+type panicCarrier struct {
+	once sync.Once
+	val  any
+}
+
+func (p *panicCarrier) run(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.once.Do(func() { p.val = r })
+		}
+	}()
+	fn()
+}
+
+// rethrow panics with the carried value, if any. The goroutines must have
+// finished.
+func (p *panicCarrier) rethrow() {
+	if p.val != nil {
+		panic(p.val)
+	}
+}
+
+// MGet retrieves the values of keys, locking each shard once. found[i]
+// reports whether keys[i] is in the map and values[i] holds its value.
+func (m *Map[K, V]) MGet(keys []K, opts ...BatchOption) (values []V, found []bool) {
+	values = make([]V, len(keys))
+	found = make([]bool, len(keys))
+	// Reads update the eviction metadata of bounded maps, which needs the
+	// write lock.
+	bounded := m.bounded()
+	m.batch(len(keys), func(i int) K { return keys[i] }, bounded, opts, func(shard *Shard[K, V], group []int) {
+		now := shard.now()
+		for _, i := range group {
+			key := keys[i]
+			shard.countGet()
+			if bounded {
+				shard.purgeExpired(key)
+			} else if shard.expiredAt(key, now) {
+				continue
+			}
+			values[i], found[i] = shard.items[key]
+			if bounded && found[i] {
+				shard.policy.access(key)
+			}
+		}
+	})
+	return values, found
+}
+
+// MSet sets all the entries of data, locking each shard once.
+func (m *Map[K, V]) MSet(data map[K]V, opts ...BatchOption) {
+	keys := make([]K, 0, len(data))
+	values := make([]V, 0, len(data))
+	for key, value := range data {
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	m.batch(len(keys), func(i int) K { return keys[i] }, true, opts, func(shard *Shard[K, V], group []int) {
+		for _, i := range group {
+			key := keys[i]
+			shard.store(key, values[i], OpSet)
+			shard.clearTTL(key)
+		}
+	})
+}
+
+// MRemove removes keys from the map, locking each shard once. removed[i]
+// reports whether keys[i] was in the map and old[i] holds its value.
+func (m *Map[K, V]) MRemove(keys []K, opts ...BatchOption) (old []V, removed []bool) {
+	old = make([]V, len(keys))
+	removed = make([]bool, len(keys))
+	m.batch(len(keys), func(i int) K { return keys[i] }, true, opts, func(shard *Shard[K, V], group []int) {
+		for _, i := range group {
+			shard.purgeExpired(keys[i])
+			old[i], removed[i] = shard.drop(keys[i], OpRemove)
+		}
+	})
+	return old, removed
+}
@@ -0,0 +1,71 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMGet(t *testing.T) {
+	for _, opts := range [][]BatchOption{nil, {WithParallelism(4)}} {
+		m := NewMap[string, int]()
+		data := make(map[string]int)
+		for i := 0; i < 100; i++ {
+			data[strconv.Itoa(i)] = i
+		}
+		m.MSet(data, opts...)
+		if m.Count() != 100 {
+			t.Fatal("MSet should set every entry.", m.Count())
+		}
+		keys := []string{"5", "missing", "99", "5"}
+		values, found := m.MGet(keys, opts...)
+		for i, want := range []bool{true, false, true, true} {
+			if found[i] != want {
+				t.Error("MGet should report which keys were found.", keys[i], found[i])
+			}
+		}
+		if values[0] != 5 || values[2] != 99 || values[3] != 5 {
+			t.Error("MGet should return the values in key order.", values)
+		}
+	}
+}
+
+func TestMRemove(t *testing.T) {
+	now := fakeClock(t)
+	m := NewMap[string, int]()
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.SetWithTTL("c", 3, time.Second)
+	*now += int64(time.Second)
+	old, removed := m.MRemove([]string{"a", "c", "x", "b"}, WithParallelism(2))
+	if !removed[0] || removed[1] || removed[2] || !removed[3] {
+		t.Error("MRemove should report which keys were removed.", removed)
+	}
+	if old[0] != 1 || old[3] != 2 {
+		t.Error("MRemove should return the old values.", old)
+	}
+	if m.Count() != 0 {
+		t.Error(m.Count())
+	}
+}
+
+func TestMGetBounded(t *testing.T) {
+	m := NewWithOptions[int, int](WithShardCount(1), WithCapacity(2))
+	m.Set(1, 1)
+	m.Set(2, 2)
+	m.MGet([]int{1})
+	m.Set(3, 3)
+	if !m.Has(1) || m.Has(2) {
+		t.Error("MGet should count as an access for eviction.")
+	}
+}
+
+func TestMSetWatch(t *testing.T) {
+	m := NewMap[string, int]()
+	w := m.Watch("a")
+	defer w.Close()
+	m.MSet(map[string]int{"a": 1, "b": 2}, WithParallelism(2))
+	if e := nextEvent(t, w); e.Op != OpSet || e.New != 1 {
+		t.Error("MSet should be reported to watchers.", e)
+	}
+}
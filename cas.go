@@ -0,0 +1,87 @@
+package cmap
+
+import "fmt"
+
+// WithEquality sets the function CompareAndSwap and CompareAndDelete use to
+// compare values. Without it values are compared with ==, which panics for
+// values that are not comparable (slices, maps, functions).
+// NewWithOptions panics if V does not match the map.
+func WithEquality[V any](fn func(a, b V) bool) Option {
+	return func(o *options) {
+		o.equal = fn
+	}
+}
+
+// casFromOptions applies the compare-and-swap related options to a new map.
+func (m *Map[K, V]) casFromOptions(o *options) {
+	if o.equal == nil {
+		m.equal = func(a, b V) bool {
+			return any(a) == any(b)
+		}
+		return
+	}
+	fn, ok := o.equal.(func(a, b V) bool)
+	if !ok {
+		panic(fmt.Sprintf("cmap: equality function %T does not match value type", o.equal))
+	}
+	m.equal = fn
+}
+
+// CompareAndSwap sets key to new if its current value equals old, and reports
+// whether it did. The entry keeps its TTL.
+func (m *Map[K, V]) CompareAndSwap(key K, old, new V) bool {
+	shard := m.lockKey(key)
+	shard.purgeExpired(key)
+	v, ok := shard.items[key]
+	swapped := ok && m.equal(v, old)
+	if swapped {
+		shard.store(key, new, OpSet)
+	}
+	m.unlock(shard)
+	return swapped
+}
+
+// CompareAndDelete removes key if its current value equals old, and reports
+// whether it did.
+func (m *Map[K, V]) CompareAndDelete(key K, old V) bool {
+	shard := m.lockKey(key)
+	shard.purgeExpired(key)
+	v, ok := shard.items[key]
+	deleted := ok && m.equal(v, old)
+	if deleted {
+		shard.drop(key, OpRemove)
+	}
+	m.unlock(shard)
+	return deleted
+}
+
+// Swap sets key to value and returns the previous value, if any.
+func (m *Map[K, V]) Swap(key K, value V) (previous V, loaded bool) {
+	shard := m.lockKey(key)
+	shard.purgeExpired(key)
+	previous, loaded = shard.items[key]
+	shard.store(key, value, OpSet)
+	shard.clearTTL(key)
+	m.unlock(shard)
+	return previous, loaded
+}
+
+// LoadOrStore returns the existing value for key if present. Otherwise it
+// stores and returns value. loaded reports whether the value was present.
+func (m *Map[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	shard := m.lockKey(key)
+	shard.purgeExpired(key)
+	actual, loaded = shard.items[key]
+	if !loaded {
+		shard.store(key, value, OpSet)
+		actual = value
+	}
+	m.unlock(shard)
+	return actual, loaded
+}
+
+// LoadAndDelete removes key and returns its previous value, if any.
+// It is Pop under the name used by sync.Map.
+func (m *Map[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	return m.Pop(key)
+}
@@ -0,0 +1,117 @@
+package cmap
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	m := NewMap[string, int]()
+	if m.CompareAndSwap("counter", 0, 1) {
+		t.Error("CompareAndSwap should fail for missing keys.")
+	}
+	m.Set("counter", 1)
+	if m.CompareAndSwap("counter", 2, 3) {
+		t.Error("CompareAndSwap should fail when the old value differs.")
+	}
+	if !m.CompareAndSwap("counter", 1, 2) {
+		t.Error("CompareAndSwap should succeed when the old value matches.")
+	}
+	if v, _ := m.Get("counter"); v != 2 {
+		t.Error("Expecting 2, got", v)
+	}
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	m := NewMap[string, int]()
+	m.Set("counter", 0)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for {
+					v, _ := m.Get("counter")
+					if m.CompareAndSwap("counter", v, v+1) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := m.Get("counter"); v != 800 {
+		t.Error("Expecting 800 increments, got", v)
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	m := NewMap[string, string]()
+	m.Set("a", "x")
+	if m.CompareAndDelete("a", "y") {
+		t.Error("CompareAndDelete should fail when the old value differs.")
+	}
+	if !m.CompareAndDelete("a", "x") || m.Has("a") {
+		t.Error("CompareAndDelete should remove a matching entry.")
+	}
+	if m.CompareAndDelete("a", "x") {
+		t.Error("CompareAndDelete should fail for missing keys.")
+	}
+}
+
+func TestSwap(t *testing.T) {
+	m := NewMap[string, int]()
+	if _, loaded := m.Swap("a", 1); loaded {
+		t.Error("Swap should not load a missing key.")
+	}
+	if old, loaded := m.Swap("a", 2); !loaded || old != 1 {
+		t.Error("Swap should return the previous value.")
+	}
+	if v, _ := m.Get("a"); v != 2 {
+		t.Error("Swap should store the new value.")
+	}
+}
+
+func TestLoadOrStore(t *testing.T) {
+	m := NewMap[string, int]()
+	if actual, loaded := m.LoadOrStore("a", 1); loaded || actual != 1 {
+		t.Error("LoadOrStore should store a missing key.")
+	}
+	if actual, loaded := m.LoadOrStore("a", 2); !loaded || actual != 1 {
+		t.Error("LoadOrStore should return the existing value.")
+	}
+	if v, loaded := m.LoadAndDelete("a"); !loaded || v != 1 || m.Has("a") {
+		t.Error("LoadAndDelete should remove the entry.")
+	}
+}
+
+func TestEqualityFunc(t *testing.T) {
+	m := NewWithOptions[string, []string](WithEquality(func(a, b []string) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}))
+	m.Set("tags", []string{"a", "b"})
+	if !m.CompareAndSwap("tags", []string{"a", "b"}, []string{"c"}) {
+		t.Error("CompareAndSwap should use the equality function.")
+	}
+	if !m.CompareAndDelete("tags", []string{"c"}) {
+		t.Error("CompareAndDelete should use the equality function.")
+	}
+}
+
+func TestEqualityFuncMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("a mismatching equality function should panic.")
+		}
+	}()
+	NewWithOptions[string, int](WithEquality(func(a, b string) bool { return a == b }))
+}
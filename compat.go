@@ -0,0 +1,145 @@
+package cmap
+
+// ConcurrentMap is a "thread" safe map of type string:Anything.
+// To avoid lock bottlenecks this map is dived to several (SHARD_COUNT) map shards.
+//
+// It is the API of the first versions of this package, kept so that existing
+// code compiles unchanged. The shards belong to a Map[string, interface{}],
+// returned by the Map method, which offers everything added since.
+type ConcurrentMap []*ConcurrentMapShared
+
+// ConcurrentMapShared is a "thread" safe string to anything map.
+type ConcurrentMapShared = Shard[string, interface{}]
+
+// UpsertCb is the UpsertFunc of a ConcurrentMap.
+type UpsertCb = UpsertFunc[interface{}]
+
+// RemoveCb is the RemoveFunc of a ConcurrentMap.
+type RemoveCb = RemoveFunc[string, interface{}]
+
+// IterCb is the IterFunc of a ConcurrentMap.
+type IterCb = IterFunc[string, interface{}]
+
+// Tuple is the Entry of a ConcurrentMap.
+type Tuple = Entry[string, interface{}]
+
+// New creates a new concurrent map.
+func New() ConcurrentMap {
+	return NewMap[string, interface{}]().table.Load().shards
+}
+
+// Map returns the map the shards belong to.
+func (m ConcurrentMap) Map() *Map[string, interface{}] {
+	return m[0].owner
+}
+
+// GetShard returns shard under given key
+func (m ConcurrentMap) GetShard(key string) *ConcurrentMapShared {
+	return m.Map().GetShard(key)
+}
+
+// MSet sets the given map to current maps.
+func (m ConcurrentMap) MSet(data map[string]interface{}) {
+	m.Map().MSet(data)
+}
+
+// Set sets the given value under the specified key.
+func (m ConcurrentMap) Set(key string, value interface{}) {
+	m.Map().Set(key, value)
+}
+
+// Upsert is Insert or Update - updates existing element or inserts a new one using UpsertCb
+func (m ConcurrentMap) Upsert(key string, value interface{}, cb UpsertCb) (res interface{}) {
+	return m.Map().Upsert(key, value, cb)
+}
+
+// SetIfAbsent Sets the given value under the specified key if no value was associated with it.
+func (m ConcurrentMap) SetIfAbsent(key string, value interface{}) bool {
+	return m.Map().SetIfAbsent(key, value)
+}
+
+// Get retrieves an element from map under given key.
+func (m ConcurrentMap) Get(key string) (interface{}, bool) {
+	return m.Map().Get(key)
+}
+
+// Count returns the number of elements within the map.
+func (m ConcurrentMap) Count() int {
+	return m.Map().Count()
+}
+
+// Has Looks up an item under specified key
+func (m ConcurrentMap) Has(key string) bool {
+	return m.Map().Has(key)
+}
+
+// Remove removes an element from the map.
+func (m ConcurrentMap) Remove(key string) {
+	m.Map().Remove(key)
+}
+
+// RemoveCb locks the shard containing the key, retrieves its current value and calls the callback with those params
+// If callback returns true and element exists, it will remove it from the map
+// Returns the value returned by the callback (even if element was not present in the map)
+func (m ConcurrentMap) RemoveCb(key string, cb RemoveCb) bool {
+	return m.Map().RemoveCb(key, cb)
+}
+
+// Pop removes an element from the map and returns it
+func (m ConcurrentMap) Pop(key string) (v interface{}, exists bool) {
+	return m.Map().Pop(key)
+}
+
+// IsEmpty checks if map is empty.
+func (m ConcurrentMap) IsEmpty() bool {
+	return m.Map().IsEmpty()
+}
+
+// Iter returns an iterator which could be used in a for range loop.
+//
+// Deprecated: using IterBuffered() will get a better performence
+func (m ConcurrentMap) Iter() <-chan Tuple {
+	return m.Map().Iter()
+}
+
+// IterBuffered returns a buffered iterator which could be used in a for range loop.
+func (m ConcurrentMap) IterBuffered() <-chan Tuple {
+	return m.Map().IterBuffered()
+}
+
+// Items returns all items as map[string]interface{}
+func (m ConcurrentMap) Items() map[string]interface{} {
+	return m.Map().Items()
+}
+
+// IterCb Callback based iterator, cheapest way to read
+// all elements in a map.
+func (m ConcurrentMap) IterCb(fn IterCb) {
+	m.Map().IterCb(fn)
+}
+
+// Keys returns all keys as []string
+func (m ConcurrentMap) Keys() []string {
+	return m.Map().Keys()
+}
+
+// MarshalJSON Reviles ConcurrentMap "private" variables to json marshal.
+func (m ConcurrentMap) MarshalJSON() ([]byte, error) {
+	return m.Map().MarshalJSON()
+}
+
+// SetNoLock sets the given value under the specified key without locking shard.
+// If already lock for outer shard, locking inside will waste kernel resource
+//
+// Deprecated: see Map.SetNoLock.
+func (m ConcurrentMap) SetNoLock(key string, value interface{}) {
+	m.Map().SetNoLock(key, value)
+}
+
+// RemoveNoLock removes an element from the map without locking shard.
+// If already lock for outer shard, locking inside will waste kernel resource
+//
+// Deprecated: see Map.RemoveNoLock.
+func (m ConcurrentMap) RemoveNoLock(key string) {
+	m.Map().RemoveNoLock(key)
+}
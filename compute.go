@@ -0,0 +1,87 @@
+package cmap
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrComputePanicked is returned to GetOrCompute callers that waited on a
+// loader which panicked.
+var ErrComputePanicked = errors.New("cmap: GetOrCompute loader panicked")
+
+// computeCall is a loader run by GetOrCompute, shared by every caller
+// waiting for the same key.
+type computeCall[V any] struct {
+	wg  sync.WaitGroup
+	val V
+	err error
+}
+
+// GetOrCompute returns the value of key, calling loader to compute it if the
+// key is missing. Concurrent callers for the same key share a single loader
+// call and its result. The loader runs, and waiters wait, without the shard
+// lock held, so other keys of the shard stay available. On error nothing is
+// stored and every waiter gets the error. If the key was set while the loader
+// ran, the value in the map wins.
+func (m *Map[K, V]) GetOrCompute(key K, loader func() (V, error)) (V, error) {
+	if !m.bounded() {
+		if v, ok := m.Get(key); ok {
+			return v, nil
+		}
+	}
+
+	shard := m.lockKey(key)
+	shard.purgeExpired(key)
+	if v, ok := shard.items[key]; ok {
+		if shard.policy != nil {
+			shard.policy.access(key)
+		}
+		m.unlock(shard)
+		return v, nil
+	}
+	if c, ok := shard.calls[key]; ok {
+		m.unlock(shard)
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := &computeCall[V]{}
+	c.wg.Add(1)
+	if shard.calls == nil {
+		shard.calls = make(map[K]*computeCall[V])
+	}
+	shard.calls[key] = c
+	m.unlock(shard)
+
+	m.runCompute(key, c, loader)
+	return c.val, c.err
+}
+
+// runCompute calls loader and publishes its result to the map and to the
+// callers waiting on c, even if loader panics or the write-ahead log fails
+// to record the value, in which case the waiters get the log error.
+func (m *Map[K, V]) runCompute(key K, c *computeCall[V], loader func() (V, error)) {
+	done := false
+	defer c.wg.Done() // last, unlock may panic
+	defer func() {
+		if !done {
+			c.err = ErrComputePanicked
+		}
+		// Resize may have moved the key, and c with it, meanwhile.
+		shard := m.lockKey(key)
+		delete(shard.calls, key)
+		if c.err == nil {
+			shard.purgeExpired(key)
+			if v, ok := shard.items[key]; ok {
+				c.val = v
+			} else {
+				shard.store(key, c.val, OpSet)
+				if shard.walErr != nil {
+					c.err = walError(shard.walErr)
+				}
+			}
+		}
+		m.unlock(shard)
+	}()
+	c.val, c.err = loader()
+	done = true
+}
@@ -0,0 +1,100 @@
+package cmap
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrCompute(t *testing.T) {
+	m := NewMap[string, int]()
+	v, err := m.GetOrCompute("answer", func() (int, error) { return 42, nil })
+	if err != nil || v != 42 {
+		t.Error("GetOrCompute should return the loaded value.")
+	}
+	v, err = m.GetOrCompute("answer", func() (int, error) {
+		t.Error("loader should not run for present keys.")
+		return 0, nil
+	})
+	if err != nil || v != 42 {
+		t.Error("GetOrCompute should return the stored value.")
+	}
+}
+
+func TestGetOrComputeSingleFlight(t *testing.T) {
+	m := NewMap[string, int]()
+	var calls int32
+	release := make(chan struct{})
+	wg := sync.WaitGroup{}
+	results := make([]int, 16)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = m.GetOrCompute("slow", func() (int, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return 7, nil
+			})
+		}(i)
+	}
+
+	// Other keys of the shard stay available while the loader runs.
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Error("Expecting one loader call, got", calls)
+	}
+	for _, r := range results {
+		if r != 7 {
+			t.Error("every caller should share the loaded value.")
+		}
+	}
+}
+
+func TestGetOrComputeError(t *testing.T) {
+	m := NewMap[string, int]()
+	boom := errors.New("boom")
+	if _, err := m.GetOrCompute("a", func() (int, error) { return 0, boom }); err != boom {
+		t.Error("GetOrCompute should return the loader error.")
+	}
+	if m.Has("a") {
+		t.Error("failed loads should not be stored.")
+	}
+	if v, err := m.GetOrCompute("a", func() (int, error) { return 1, nil }); err != nil || v != 1 {
+		t.Error("a failed load should be retried by the next caller.")
+	}
+}
+
+func TestGetOrComputePanic(t *testing.T) {
+	m := NewMap[string, int]()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	waiterErr := make(chan error)
+	go func() {
+		defer func() { recover() }()
+		m.GetOrCompute("a", func() (int, error) {
+			close(started)
+			<-release
+			panic("loader")
+		})
+	}()
+	<-started
+	go func() {
+		_, err := m.GetOrCompute("a", func() (int, error) { return 1, nil })
+		waiterErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if err := <-waiterErr; err != ErrComputePanicked && err != nil {
+		t.Error("waiters should be released when the loader panics, got", err)
+	}
+}
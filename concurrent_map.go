@@ -1,188 +1,418 @@
 package cmap
 
 import (
-	"encoding/json"
+	"bytes"
 	"sync"
+	"sync/atomic"
 )
 
+// SHARD_COUNT is the number of shards of maps created by New.
+// Changing it does not affect maps that already exist.
 var SHARD_COUNT = 32
 
-// ConcurrentMap is a "thread" safe map of type string:Anything.
-// To avoid lock bottlenecks this map is dived to several (SHARD_COUNT) map shards.
-type ConcurrentMap []*ConcurrentMapShared
+// Map is a "thread" safe map of type K:V.
+// To avoid lock bottlenecks this map is dived to several map shards.
+type Map[K comparable, V any] struct {
+	table    atomic.Pointer[shardTable[K, V]] // see Resize
+	gate     resizeGate
+	resizing sync.Mutex      // serializes Resize
+	opts     options         // kept for the shards created by Resize
+	budget   *capacityBudget // shared by all shards with WithCapacity
+	growing  atomic.Bool     // a WithAutoGrow check or Resize is running
+	grown    atomic.Bool     // WithAutoGrow stopped, see grow
+	sharding func(key K) uint32
+	hasher   Hasher // set for string keyed maps, used by the *Bytes methods
+	equal    func(a, b V) bool
+	keyCmp   func(a, b K) int // set WithKeyOrder
+
+	onExpire    func(key K, v V)
+	onEvict     func(key K, v V, reason EvictReason)
+	wal         *walLog[K, V]
+	watchMu     sync.Mutex                       // serializes changes to watchers
+	watchers    atomic.Pointer[[]*Watcher[K, V]] // nil when nobody watches
+	indexMu     sync.Mutex                       // serializes AddIndex
+	indexes     atomic.Pointer[map[string]*valueIndex[K, V]]
+	stopJanitor chan struct{}
+	janitorDone chan struct{}
+	closeOnce   sync.Once
+}
 
-// ConcurrentMapShared is a "thread" safe string to anything map.
-type ConcurrentMapShared struct {
-	items        map[string]interface{}
+// Shard is a "thread" safe K to V map, one of the shards of a Map.
+type Shard[K comparable, V any] struct {
+	items        map[K]V
 	sync.RWMutex // Read Write mutex, guards access to internal map.
+
+	owner   *Map[K, V]
+	expires map[K]int64 // TTL deadlines in unix nanoseconds, see SetWithTTL.
+	moved   atomic.Bool // set under the write lock once Resize moved the entries out
+	seq     uint64      // creation order, the order in which shards are locked together
+
+	policy     evictionPolicy[K] // nil when the map is not bounded
+	capacity   int               // per shard capacity, unused with a global budget
+	budget     *capacityBudget   // global capacity shared by all shards
+	overBudget bool              // over the budget with nothing else to evict, see evictOtherShards
+	growMark   int               // entries past which the shard asks for a grow check, see maybeGrow
+	index      *skipList[K]      // sorted keys, nil unless WithKeyOrder
+
+	pending  []notification[K, V]  // callbacks queued by writes, delivered by unlock
+	walErr   error                 // failure to log a write, raised by unlock
+	events   []Event[K, V]         // watch events queued by writes, delivered by unlock
+	delivery deliveryQueue         // keeps the events of the shard in order, see unlock
+	calls    map[K]*computeCall[V] // loaders in flight, see GetOrCompute
+	stats    *shardStats           // nil unless the map was created WithMetrics
+
+	snap  atomic.Pointer[readSnapshot[K, V]] // nil unless WithReadMostly
+	dirty bool                               // entries changed since the last publish
+
+	// Shards are allocated next to each other, keep the locks of neighbours
+	// off the same cache lines (and their prefetched pairs).
+	_ [128]byte
+}
+
+// notification is an OnExpire or OnEvict callback queued while a shard lock is held.
+type notification[K comparable, V any] struct {
+	key     K
+	val     V
+	expired bool
+	reason  EvictReason
+}
+
+// store sets key to value, keeping the eviction metadata in sync and evicting
+// entries if the shard grows over capacity. op is reported to watchers. An
+// expired entry under key is expired first, so the write sees no old value.
+// Write lock must be held.
+func (s *Shard[K, V]) store(key K, value V, op EventOp) {
+	s.purgeExpired(key)
+	if s.walErr != nil {
+		return
+	}
+	if w := s.owner.wal; w != nil {
+		if err := w.logSet(key, value); err != nil {
+			s.walErr = err
+			return
+		}
+	}
+	s.storeLogged(key, value, op)
+}
+
+// storeLogged is store for a write the log already holds, see logTx.
+// Write lock must be held.
+func (s *Shard[K, V]) storeLogged(key K, value V, op EventOp) {
+	s.countOp(op)
+	if m := s.owner; m.watchers.Load() != nil || m.indexes.Load() != nil {
+		old, existed := s.items[key]
+		if m.watched(key) {
+			s.events = append(s.events, Event[K, V]{Op: op, Key: key, Old: old, New: value, Existed: existed})
+		}
+		s.reindex(key, old, existed, value, false)
+	}
+	if s.put(key, value) {
+		s.evictOverflow(&key)
+	}
+	s.maybeGrow()
+}
+
+// put sets key to value and updates the eviction metadata, without logging or
+// evicting. It reports whether key is new to a bounded shard.
+// Write lock must be held.
+func (s *Shard[K, V]) put(key K, value V) bool {
+	_, exists := s.items[key]
+	s.items[key] = value
+	s.dirty = true
+	if !exists && s.index != nil {
+		s.index.insert(key)
+	}
+	if s.policy == nil {
+		return false
+	}
+	if exists {
+		s.policy.access(key)
+		return false
+	}
+	s.policy.add(key)
+	if s.budget != nil {
+		s.budget.used.Add(1)
+	}
+	return true
 }
 
-// New creates a new concurrent map.
-func New() ConcurrentMap {
-	m := make(ConcurrentMap, SHARD_COUNT)
-	for i := 0; i < SHARD_COUNT; i++ {
-		m[i] = &ConcurrentMapShared{items: make(map[string]interface{})}
+// drop deletes key and everything tracked about it. op is reported to
+// watchers. An expired entry is expired instead, and reported as missing.
+// Write lock must be held.
+func (s *Shard[K, V]) drop(key K, op EventOp) (V, bool) {
+	v, ok := s.items[key]
+	if !ok {
+		return v, false
+	}
+	if op != OpExpire && s.isExpired(key) {
+		s.expire(key)
+		return *new(V), false
+	}
+	if w := s.owner.wal; w != nil {
+		if err := w.logDelete(key); err != nil {
+			s.walErr = err
+			return v, false
+		}
 	}
-	return m
+	return s.dropLogged(key, op)
 }
 
-// GetShard returns shard under given key
-func (m ConcurrentMap) GetShard(key string) *ConcurrentMapShared {
-	return m[uint(fnv32(key))%uint(SHARD_COUNT)]
+// dropLogged is drop for a delete the log already holds, see logTx.
+// Write lock must be held.
+func (s *Shard[K, V]) dropLogged(key K, op EventOp) (V, bool) {
+	v, ok := s.items[key]
+	if !ok {
+		return v, false
+	}
+	s.countOp(op)
+	if s.owner.watched(key) {
+		s.events = append(s.events, Event[K, V]{Op: op, Key: key, Old: v, Existed: true})
+	}
+	if s.owner.indexes.Load() != nil {
+		s.reindex(key, v, true, *new(V), true)
+	}
+	delete(s.items, key)
+	delete(s.expires, key)
+	s.dirty = true
+	if s.index != nil {
+		s.index.remove(key)
+	}
+	if s.policy != nil {
+		s.policy.remove(key)
+		if s.budget != nil {
+			s.budget.used.Add(-1)
+		}
+	}
+	return v, true
 }
 
-// MSet sets the given map to current maps.
-func (m ConcurrentMap) MSet(data map[string]interface{}) {
-	for key, value := range data {
-		shard := m.GetShard(key)
-		shard.Lock()
-		shard.items[key] = value
-		shard.Unlock()
+// unlock releases the write lock of shard, then delivers the callbacks and
+// watch events queued while it was held, so receivers may use the map freely.
+// The events take a turn in the delivery queue of the shard before the lock
+// is released, so they reach watchers in the order they happened, while a
+// slow watcher holds back the writers of watched keys only. A write the
+// write-ahead log failed to record panics last, see OpenWAL.
+func (m *Map[K, V]) unlock(shard *Shard[K, V]) {
+	pending, events, overBudget, walErr := shard.pending, shard.events, shard.overBudget, shard.walErr
+	shard.pending, shard.events, shard.overBudget, shard.walErr = nil, nil, false, nil
+	var turn uint64
+	if len(events) > 0 {
+		turn = shard.delivery.take()
+	}
+	shard.release()
+	if len(events) > 0 {
+		shard.delivery.wait(turn)
+		m.publish(events)
+		shard.delivery.done()
 	}
+	if overBudget {
+		m.evictOtherShards()
+	}
+	for _, n := range pending {
+		if n.expired {
+			m.onExpire(n.key, n.val)
+		} else {
+			m.onEvict(n.key, n.val, n.reason)
+		}
+	}
+	if walErr != nil {
+		panicWAL(walErr)
+	}
+}
+
+// NewMap creates a new concurrent map with SHARD_COUNT shards.
+func NewMap[K comparable, V any]() *Map[K, V] {
+	return NewWithOptions[K, V]()
+}
+
+// ShardCount returns the number of shards of the map. While Resize runs, it
+// is the count entries are moving from.
+func (m *Map[K, V]) ShardCount() int {
+	return len(m.table.Load().shards)
+}
+
+// GetShard returns shard under given key. While Resize runs, the key may move
+// to another shard before the returned one is locked, after which the
+// returned shard no longer holds it and SetNoLock and RemoveNoLock panic
+// until Resize returns.
+// WithShard locks the shard owning the key whatever Resize does.
+func (m *Map[K, V]) GetShard(key K) *Shard[K, V] {
+	return m.shardFor(m.sharding(key))
 }
 
 // Set sets the given value under the specified key.
-func (m ConcurrentMap) Set(key string, value interface{}) {
+func (m *Map[K, V]) Set(key K, value V) {
 	// Get map shard.
-	shard := m.GetShard(key)
-	shard.Lock()
-	shard.items[key] = value
-	shard.Unlock()
+	shard := m.lockKey(key)
+	shard.store(key, value, OpSet)
+	shard.clearTTL(key)
+	m.unlock(shard)
 }
 
-// UpsertCb Callback to return new element to be inserted into the map
+// UpsertFunc Callback to return new element to be inserted into the map
 // It is called while lock is held, therefore it MUST NOT
 // try to access other keys in same map, as it can lead to deadlock since
 // Go sync.RWLock is not reentrant
-type UpsertCb func(exist bool, valueInMap interface{}, newValue interface{}) interface{}
+type UpsertFunc[V any] func(exist bool, valueInMap V, newValue V) V
 
-// Upsert is Insert or Update - updates existing element or inserts a new one using UpsertCb
-func (m ConcurrentMap) Upsert(key string, value interface{}, cb UpsertCb) (res interface{}) {
-	shard := m.GetShard(key)
-	shard.Lock()
+// Upsert is Insert or Update - updates existing element or inserts a new one using UpsertFunc
+// An updated element keeps its TTL.
+func (m *Map[K, V]) Upsert(key K, value V, cb UpsertFunc[V]) (res V) {
+	shard := m.lockKey(key)
+	shard.purgeExpired(key)
 	v, ok := shard.items[key]
 	res = cb(ok, v, value)
-	shard.items[key] = res
-	shard.Unlock()
+	shard.store(key, res, OpUpsert)
+	m.unlock(shard)
 	return res
 }
 
 // SetIfAbsent Sets the given value under the specified key if no value was associated with it.
-func (m ConcurrentMap) SetIfAbsent(key string, value interface{}) bool {
+func (m *Map[K, V]) SetIfAbsent(key K, value V) bool {
 	// Get map shard.
-	shard := m.GetShard(key)
-	shard.Lock()
+	shard := m.lockKey(key)
+	shard.purgeExpired(key)
 	_, ok := shard.items[key]
 	if !ok {
-		shard.items[key] = value
+		shard.store(key, value, OpSet)
 	}
-	shard.Unlock()
+	m.unlock(shard)
 	return !ok
 }
 
 // Get retrieves an element from map under given key.
-func (m ConcurrentMap) Get(key string) (interface{}, bool) {
+func (m *Map[K, V]) Get(key K) (V, bool) {
+	if m.bounded() {
+		// Reads update the eviction metadata, which needs the write lock.
+		return m.getTracked(m.sharding(key), key)
+	}
+	if m.opts.readMostly {
+		shard, snap := m.loadSnapshot(m.sharding(key))
+		shard.countGet()
+		val, ok, expired := snap.lookup(key)
+		if expired {
+			m.expireKey(key)
+		}
+		return val, ok
+	}
 	// Get shard
-	shard := m.GetShard(key)
-	shard.RLock()
+	shard := m.rlockKey(key)
+	shard.countGet()
 	// Get item from shard.
 	val, ok := shard.items[key]
+	expired := ok && shard.isExpired(key)
 	shard.RUnlock()
+	if expired {
+		m.expireKey(key)
+		return *new(V), false
+	}
 	return val, ok
 }
 
 // Count returns the number of elements within the map.
-func (m ConcurrentMap) Count() int {
+func (m *Map[K, V]) Count() int {
 	count := 0
-	for i := 0; i < SHARD_COUNT; i++ {
-		shard := m[i]
+	for _, shard := range m.pinShards() {
 		shard.RLock()
-		count += len(shard.items)
+		count += shard.count()
 		shard.RUnlock()
 	}
+	m.unpinShards()
 	return count
 }
 
 // Has Looks up an item under specified key
-func (m ConcurrentMap) Has(key string) bool {
+func (m *Map[K, V]) Has(key K) bool {
+	if m.opts.readMostly {
+		shard, snap := m.loadSnapshot(m.sharding(key))
+		shard.countGet()
+		_, ok, expired := snap.lookup(key)
+		if expired {
+			m.expireKey(key)
+		}
+		return ok
+	}
 	// Get shard
-	shard := m.GetShard(key)
-	shard.RLock()
+	shard := m.rlockKey(key)
+	shard.countGet()
 	// See if element is within shard.
 	_, ok := shard.items[key]
+	expired := ok && shard.isExpired(key)
 	shard.RUnlock()
+	if expired {
+		m.expireKey(key)
+		return false
+	}
 	return ok
 }
 
 // Remove removes an element from the map.
-func (m ConcurrentMap) Remove(key string) {
+func (m *Map[K, V]) Remove(key K) {
 	// Try to get shard.
-	shard := m.GetShard(key)
-	shard.Lock()
-	delete(shard.items, key)
-	shard.Unlock()
+	shard := m.lockKey(key)
+	shard.drop(key, OpRemove)
+	m.unlock(shard)
 }
 
-// RemoveCb is a callback executed in a map.RemoveCb() call, while Lock is held
+// RemoveFunc is a callback executed in a map.RemoveCb() call, while Lock is held
 // If returns true, the element will be removed from the map
-type RemoveCb func(key string, v interface{}, exists bool) bool
+type RemoveFunc[K any, V any] func(key K, v V, exists bool) bool
 
 // RemoveCb locks the shard containing the key, retrieves its current value and calls the callback with those params
 // If callback returns true and element exists, it will remove it from the map
 // Returns the value returned by the callback (even if element was not present in the map)
-func (m ConcurrentMap) RemoveCb(key string, cb RemoveCb) bool {
+func (m *Map[K, V]) RemoveCb(key K, cb RemoveFunc[K, V]) bool {
 	// Try to get shard.
-	shard := m.GetShard(key)
-	shard.Lock()
+	shard := m.lockKey(key)
+	shard.purgeExpired(key)
 	v, ok := shard.items[key]
 	remove := cb(key, v, ok)
 	if remove && ok {
-		delete(shard.items, key)
+		shard.drop(key, OpRemove)
 	}
-	shard.Unlock()
+	m.unlock(shard)
 	return remove
 }
 
 // Pop removes an element from the map and returns it
-func (m ConcurrentMap) Pop(key string) (v interface{}, exists bool) {
+func (m *Map[K, V]) Pop(key K) (v V, exists bool) {
 	// Try to get shard.
-	shard := m.GetShard(key)
-	shard.Lock()
-	v, exists = shard.items[key]
-	delete(shard.items, key)
-	shard.Unlock()
+	shard := m.lockKey(key)
+	shard.purgeExpired(key)
+	v, exists = shard.drop(key, OpPop)
+	m.unlock(shard)
 	return v, exists
 }
 
 // IsEmpty checks if map is empty.
-func (m ConcurrentMap) IsEmpty() bool {
+func (m *Map[K, V]) IsEmpty() bool {
 	return m.Count() == 0
 }
 
-// Tuple is used by the Iter & IterBuffered functions to wrap two variables together over a channel,
-type Tuple struct {
-	Key string
-	Val interface{}
+// Entry is used by the Iter & IterBuffered functions to wrap two variables together over a channel,
+type Entry[K comparable, V any] struct {
+	Key K
+	Val V
 }
 
 // Iter returns an iterator which could be used in a for range loop.
 //
 // Deprecated: using IterBuffered() will get a better performence
-func (m ConcurrentMap) Iter() <-chan Tuple {
+func (m *Map[K, V]) Iter() <-chan Entry[K, V] {
 	chans := snapshot(m)
-	ch := make(chan Tuple)
+	ch := make(chan Entry[K, V])
 	go fanIn(chans, ch)
 	return ch
 }
 
 // IterBuffered returns a buffered iterator which could be used in a for range loop.
-func (m ConcurrentMap) IterBuffered() <-chan Tuple {
+func (m *Map[K, V]) IterBuffered() <-chan Entry[K, V] {
 	chans := snapshot(m)
 	total := 0
 	for _, c := range chans {
 		total += cap(c)
 	}
-	ch := make(chan Tuple, total)
+	ch := make(chan Entry[K, V], total)
 	go fanIn(chans, ch)
 	return ch
 }
@@ -191,19 +421,26 @@ func (m ConcurrentMap) IterBuffered() <-chan Tuple {
 // which likely takes a snapshot of `m`.
 // It returns once the size of each buffered channel is determined,
 // before all the channels are populated using goroutines.
-func snapshot(m ConcurrentMap) (chans []chan Tuple) {
-	chans = make([]chan Tuple, SHARD_COUNT)
+func snapshot[K comparable, V any](m *Map[K, V]) (chans []chan Entry[K, V]) {
+	shards := m.pinShards()
+	// The shards stay pinned until every one of them is read locked.
+	defer m.unpinShards()
+	chans = make([]chan Entry[K, V], len(shards))
 	wg := sync.WaitGroup{}
-	wg.Add(SHARD_COUNT)
+	wg.Add(len(shards))
 	// Foreach shard.
-	for index, shard := range m {
-		go func(index int, shard *ConcurrentMapShared) {
+	for index, shard := range shards {
+		go func(index int, shard *Shard[K, V]) {
 			// Foreach key, value pair.
 			shard.RLock()
-			chans[index] = make(chan Tuple, len(shard.items))
+			chans[index] = make(chan Entry[K, V], shard.count())
 			wg.Done()
+			now := shard.now()
 			for key, val := range shard.items {
-				chans[index] <- Tuple{key, val}
+				if shard.expiredAt(key, now) {
+					continue
+				}
+				chans[index] <- Entry[K, V]{key, val}
 			}
 			shard.RUnlock()
 			close(chans[index])
@@ -214,11 +451,11 @@ func snapshot(m ConcurrentMap) (chans []chan Tuple) {
 }
 
 // fanIn reads elements from channels `chans` into channel `out`
-func fanIn(chans []chan Tuple, out chan Tuple) {
+func fanIn[K comparable, V any](chans []chan Entry[K, V], out chan Entry[K, V]) {
 	wg := sync.WaitGroup{}
 	wg.Add(len(chans))
 	for _, ch := range chans {
-		go func(ch chan Tuple) {
+		go func(ch chan Entry[K, V]) {
 			for t := range ch {
 				out <- t
 			}
@@ -229,9 +466,9 @@ func fanIn(chans []chan Tuple, out chan Tuple) {
 	close(out)
 }
 
-// Items returns all items as map[string]interface{}
-func (m ConcurrentMap) Items() map[string]interface{} {
-	tmp := make(map[string]interface{})
+// Items returns all items as map[K]V
+func (m *Map[K, V]) Items() map[K]V {
+	tmp := make(map[K]V)
 
 	// Insert items to temporary map.
 	for item := range m.IterBuffered() {
@@ -241,38 +478,50 @@ func (m ConcurrentMap) Items() map[string]interface{} {
 	return tmp
 }
 
-// IterCb Iterator callback,called for every key,value found in
+// IterFunc Iterator callback,called for every key,value found in
 // maps. RLock is held for all calls for a given shard
 // therefore callback sess consistent view of a shard,
 // but not across the shards
-type IterCb func(key string, v interface{})
+type IterFunc[K any, V any] func(key K, v V)
 
 // IterCb Callback based iterator, cheapest way to read
 // all elements in a map.
-func (m ConcurrentMap) IterCb(fn IterCb) {
-	for idx := range m {
-		shard := (m)[idx]
+func (m *Map[K, V]) IterCb(fn IterFunc[K, V]) {
+	shards := m.pinShards()
+	defer m.unpinShards()
+	for idx := range shards {
+		shard := shards[idx]
 		shard.RLock()
+		now := shard.now()
 		for key, value := range shard.items {
+			if shard.expiredAt(key, now) {
+				continue
+			}
 			fn(key, value)
 		}
 		shard.RUnlock()
 	}
 }
 
-// Keys returns all keys as []string
-func (m ConcurrentMap) Keys() []string {
+// Keys returns all keys as []K
+func (m *Map[K, V]) Keys() []K {
+	shards := m.pinShards()
 	count := m.Count()
-	ch := make(chan string, count)
+	ch := make(chan K, count)
 	go func() {
+		defer m.unpinShards()
 		// Foreach shard.
 		wg := sync.WaitGroup{}
-		wg.Add(SHARD_COUNT)
-		for _, shard := range m {
-			go func(shard *ConcurrentMapShared) {
+		wg.Add(len(shards))
+		for _, shard := range shards {
+			go func(shard *Shard[K, V]) {
 				// Foreach key, value pair.
 				shard.RLock()
+				now := shard.now()
 				for key := range shard.items {
+					if shard.expiredAt(key, now) {
+						continue
+					}
 					ch <- key
 				}
 				shard.RUnlock()
@@ -284,23 +533,21 @@ func (m ConcurrentMap) Keys() []string {
 	}()
 
 	// Generate keys
-	keys := make([]string, 0, count)
+	keys := make([]K, 0, count)
 	for k := range ch {
 		keys = append(keys, k)
 	}
 	return keys
 }
 
-// MarshalJSON Reviles ConcurrentMap "private" variables to json marshal.
-func (m ConcurrentMap) MarshalJSON() ([]byte, error) {
-	// Create a temporary map, which will hold all item spread across shards.
-	tmp := make(map[string]interface{})
-
-	// Insert items to temporary map.
-	for item := range m.IterBuffered() {
-		tmp[item.Key] = item.Val
+// MarshalJSON Reviles Map "private" variables to json marshal.
+// The map is encoded one shard at a time, see EncodeJSON.
+func (m *Map[K, V]) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.EncodeJSON(&buf); err != nil {
+		return nil, err
 	}
-	return json.Marshal(tmp)
+	return buf.Bytes(), nil
 }
 
 func fnv32(key string) uint32 {
@@ -313,47 +560,49 @@ func fnv32(key string) uint32 {
 	return hash
 }
 
-// Concurrent map uses Interface{} as its value, therefor JSON Unmarshal
-// will probably won't know which to type to unmarshal into, in such case
-// we'll end up with a value of type map[string]interface{}, In most cases this isn't
-// out value type, this is why we've decided to remove this functionality.
-
-// func (m *ConcurrentMap) UnmarshalJSON(b []byte) (err error) {
-// 	// Reverse process of Marshal.
-
-// 	tmp := make(map[string]interface{})
-
-// 	// Unmarshal into a single map.
-// 	if err := json.Unmarshal(b, &tmp); err != nil {
-// 		return nil
-// 	}
-
-// 	// foreach key,value pair in temporary map insert into our concurrent map.
-// 	for key, val := range tmp {
-// 		m.Set(key, val)
-// 	}
-// 	return nil
-// }
-
 /// ------------------- Mod ----------------------------------
 
 // SetNoLock sets the given value under the specified key without locking shard.
 // If already lock for outer shard, locking inside will waste kernel resource
-func (m ConcurrentMap) SetNoLock(key string, value interface{}) {
+//
+// Deprecated: while Resize runs, it panics if the shard owning the key is
+// not locked, as when Resize moved the key after GetShard. Otherwise only
+// builds with the cmapdebug tag check the lock. Use Set on the handle given
+// by WithShard instead.
+func (m *Map[K, V]) SetNoLock(key K, value V) {
 	// Get map shard.
-	shard := m.GetShard(key)
+	shard := m.lockedShard(key)
 	// shard.Lock()
-	shard.items[key] = value
+	shard.store(key, value, OpSet)
+	shard.clearTTL(key)
 	// shard.Unlock()
 }
 
 // RemoveNoLock removes an element from the map without locking shard.
 // If already lock for outer shard, locking inside will waste kernel resource
-func (m ConcurrentMap) RemoveNoLock(key string) {
+//
+// Deprecated: it panics like SetNoLock. Use Remove on the handle given by
+// WithShard instead.
+func (m *Map[K, V]) RemoveNoLock(key K) {
 	// Try to get shard.
-	shard := m.GetShard(key)
+	shard := m.lockedShard(key)
 	// shard.Lock()
-	delete(shard.items, key)
+	shard.drop(key, OpRemove)
 	// return len(shard.items) > 0
 	// shard.Unlock()
 }
+
+// lockedShard returns the shard owning key, which the caller of a NoLock
+// method must have locked. While Resize runs, a shard returned by GetShard
+// and moved before it was locked no longer owns the key, so the caller then
+// holds the wrong lock and the owner is likely free: panic rather than write
+// to it. Other misuse is left to the cmapdebug checks.
+func (m *Map[K, V]) lockedShard(key K) *Shard[K, V] {
+	shard := m.GetShard(key)
+	if m.table.Load().next.Load() != nil && shard.RWMutex.TryLock() {
+		shard.RWMutex.Unlock()
+		panic("cmap: NoLock method called without the lock of the shard owning the key, which Resize moved")
+	}
+	assertWriteLocked(&shard.RWMutex)
+	return shard
+}
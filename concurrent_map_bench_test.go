@@ -307,3 +307,120 @@ func BenchmarkKeys(b *testing.B) {
 		m.Keys()
 	}
 }
+
+func BenchmarkMSet(b *testing.B) {
+	m := NewMap[string, int]()
+	data := make(map[string]int, 10000)
+	for i := 0; i < 10000; i++ {
+		data[strconv.Itoa(i)] = i
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.MSet(data)
+	}
+}
+
+func BenchmarkSetLoop(b *testing.B) {
+	m := NewMap[string, int]()
+	data := make(map[string]int, 10000)
+	for i := 0; i < 10000; i++ {
+		data[strconv.Itoa(i)] = i
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for k, v := range data {
+			m.Set(k, v)
+		}
+	}
+}
+
+// hotKeys are read by every goroutine of the read benchmarks, so readers of
+// the same shard contend.
+var hotKeys = func() []string {
+	keys := make([]string, 16)
+	for i := range keys {
+		keys[i] = "hot" + strconv.Itoa(i)
+	}
+	return keys
+}()
+
+func benchmarkHotGet(b *testing.B, m *Map[string, int]) {
+	for i, key := range hotKeys {
+		m.Set(key, i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			m.Get(hotKeys[i%len(hotKeys)])
+		}
+	})
+}
+
+func BenchmarkHotGet(b *testing.B) {
+	benchmarkHotGet(b, NewMap[string, int]())
+}
+
+func BenchmarkHotGetReadMostly(b *testing.B) {
+	benchmarkHotGet(b, NewWithOptions[string, int](WithReadMostly()))
+}
+
+func BenchmarkHotGetSyncMap(b *testing.B) {
+	var m sync.Map
+	for i, key := range hotKeys {
+		m.Store(key, i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			m.Load(hotKeys[i%len(hotKeys)])
+		}
+	})
+}
+
+// benchmarkReadHeavy does one write per 16 reads over 1024 keys.
+func benchmarkReadHeavy(b *testing.B, m *Map[string, int]) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		m.Set(keys[i], i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			key := keys[i%len(keys)]
+			if i%16 == 0 {
+				m.Set(key, i)
+			} else {
+				m.Get(key)
+			}
+		}
+	})
+}
+
+func BenchmarkReadHeavy(b *testing.B) {
+	benchmarkReadHeavy(b, NewMap[string, int]())
+}
+
+func BenchmarkReadHeavyReadMostly(b *testing.B) {
+	benchmarkReadHeavy(b, NewWithOptions[string, int](WithReadMostly()))
+}
+
+func BenchmarkReadHeavySyncMap(b *testing.B) {
+	var m sync.Map
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		m.Store(keys[i], i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			key := keys[i%len(keys)]
+			if i%16 == 0 {
+				m.Store(key, i)
+			} else {
+				m.Load(key)
+			}
+		}
+	})
+}
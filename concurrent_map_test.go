@@ -667,3 +667,111 @@ func TestUnDrainedIterBuffered(t *testing.T) {
 		t.Error("We should have counted 200 elements.")
 	}
 }
+
+func TestTypedValues(t *testing.T) {
+	m := NewMap[string, Animal]()
+	m.Set("elephant", Animal{"elephant"})
+
+	elephant, ok := m.Get("elephant")
+	if !ok || elephant.name != "elephant" {
+		t.Error("typed Get should return the stored value without assertion.")
+	}
+
+	missing, ok := m.Get("monkey")
+	if ok || missing != (Animal{}) {
+		t.Error("missing typed value should be the zero value.")
+	}
+
+	res := m.Upsert("elephant", Animal{"mammoth"}, func(exist bool, valueInMap, newValue Animal) Animal {
+		if !exist {
+			t.Error("elephant should exist.")
+		}
+		return Animal{valueInMap.name + "/" + newValue.name}
+	})
+	if res.name != "elephant/mammoth" {
+		t.Error("Upsert returned", res.name)
+	}
+}
+
+func TestIntKeys(t *testing.T) {
+	m := NewMap[int, string]()
+	for i := 0; i < 100; i++ {
+		m.Set(i, strconv.Itoa(i))
+	}
+
+	if m.Count() != 100 {
+		t.Error("Expecting 100 element within map.")
+	}
+
+	for i := 0; i < 100; i++ {
+		if v, ok := m.Get(i); !ok || v != strconv.Itoa(i) {
+			t.Error("wrong value for key", i)
+		}
+	}
+
+	j, err := json.Marshal(m)
+	if err != nil {
+		t.Error(err)
+	}
+	tmp := map[int]string{}
+	if err := json.Unmarshal(j, &tmp); err != nil || len(tmp) != 100 {
+		t.Error("int keyed map should marshal into a json object")
+	}
+}
+
+func TestConcurrentMapShares(t *testing.T) {
+	m := New()
+	m.Set("elephant", Animal{"elephant"})
+	m.Map().Set("answer", 42)
+
+	if v, ok := m.Get("answer"); !ok || v.(int) != 42 {
+		t.Error("ConcurrentMap should see the entries of its Map.")
+	}
+	if v, ok := m.Map().Get("elephant"); !ok || v.(Animal).name != "elephant" {
+		t.Error("Map should see the entries of its ConcurrentMap.")
+	}
+	if len(m) != SHARD_COUNT || m.GetShard("answer") != m.Map().GetShard("answer") {
+		t.Error("ConcurrentMap should hold the shards of its Map.")
+	}
+}
+
+func TestShardCountPerMap(t *testing.T) {
+	small := NewWithOptions[string, int](WithShardCount(4))
+	large := NewWithOptions[string, int](WithShardCount(1024))
+
+	if small.ShardCount() != 4 || large.ShardCount() != 1024 {
+		t.Error("maps should keep their own shard count.")
+	}
+
+	for i := 0; i < 100; i++ {
+		small.Set(strconv.Itoa(i), i)
+		large.Set(strconv.Itoa(i), i)
+	}
+
+	// Changing the package default must not affect existing maps.
+	old := SHARD_COUNT
+	SHARD_COUNT = 7
+	defer func() { SHARD_COUNT = old }()
+
+	if small.Count() != 100 || large.Count() != 100 {
+		t.Error("Expecting 100 element within each map.")
+	}
+	if len(small.Keys()) != 100 || len(large.Items()) != 100 {
+		t.Error("Keys and Items should see every shard.")
+	}
+	if v, ok := small.Get("42"); !ok || v != 42 {
+		t.Error("Get should hash with the map's own shard count.")
+	}
+	if NewMap[string, int]().ShardCount() != 7 {
+		t.Error("New should use the current SHARD_COUNT.")
+	}
+}
+
+func TestInvalidShardCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("zero shards should panic.")
+		}
+	}()
+	NewWithOptions[string, int](WithShardCount(0))
+}
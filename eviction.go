@@ -0,0 +1,554 @@
+package cmap
+
+import (
+	"container/heap"
+	"fmt"
+	"sync/atomic"
+)
+
+// EvictionPolicy selects which entries a bounded map evicts when it is full.
+type EvictionPolicy int
+
+const (
+	// LRU evicts the least recently used entry.
+	LRU EvictionPolicy = iota
+	// LFU evicts the least frequently used entry, the least recently used
+	// one among equally frequent entries.
+	LFU
+	// TinyLFU is W-TinyLFU: new entries enter a small LRU window and are only
+	// admitted to the main segmented LRU if they are estimated to be used more
+	// often than the entry they would replace.
+	TinyLFU
+)
+
+// EvictReason tells an OnEvict callback why an entry was evicted.
+type EvictReason int
+
+const (
+	// EvictCapacity means the entry made room for a new one.
+	EvictCapacity EvictReason = iota
+	// EvictRejected means a new entry leaving the TinyLFU window was refused
+	// by the admission filter.
+	EvictRejected
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictRejected:
+		return "rejected"
+	}
+	return fmt.Sprintf("EvictReason(%d)", int(r))
+}
+
+// WithCapacity bounds the total number of entries of the map. Writes evict
+// from the shard being written, or, when that shard holds nothing else to
+// evict, from the other shards in turn once its lock is released, so the
+// budget is only exceeded while a write is in progress.
+func WithCapacity(n int) Option {
+	return func(o *options) {
+		o.capacity = n
+	}
+}
+
+// WithShardCapacity bounds the number of entries of every shard.
+func WithShardCapacity(n int) Option {
+	return func(o *options) {
+		o.shardCapacity = n
+	}
+}
+
+// WithEvictionPolicy sets the eviction policy of a bounded map (LRU by default).
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithOnEvict registers fn to be called for every evicted entry. fn runs after
+// the shard lock has been released.
+// NewWithOptions panics if K and V do not match the map.
+func WithOnEvict[K comparable, V any](fn func(key K, v V, reason EvictReason)) Option {
+	return func(o *options) {
+		o.onEvict = fn
+	}
+}
+
+// capacityBudget counts the entries of a map with a global capacity.
+type capacityBudget struct {
+	limit int64
+	used  atomic.Int64
+	next  atomic.Uint64 // the shard evictOtherShards starts with
+}
+
+// evictionFromOptions applies the eviction related options to a new map.
+func (m *Map[K, V]) evictionFromOptions(o *options) {
+	if o.onEvict != nil {
+		fn, ok := o.onEvict.(func(key K, v V, reason EvictReason))
+		if !ok {
+			panic(fmt.Sprintf("cmap: OnEvict callback %T does not match map types", o.onEvict))
+		}
+		m.onEvict = fn
+	}
+	if o.capacity > 0 && o.shardCapacity > 0 {
+		panic("cmap: WithCapacity and WithShardCapacity are exclusive")
+	}
+	if o.capacity > 0 {
+		m.budget = &capacityBudget{limit: int64(o.capacity)}
+	}
+}
+
+// configureEviction sets up the eviction policy of new shards, sized for a
+// table of len(shards) shards.
+func (m *Map[K, V]) configureEviction(shards []*Shard[K, V]) {
+	o := &m.opts
+	if o.capacity <= 0 && o.shardCapacity <= 0 {
+		return
+	}
+	shardCapacity := o.shardCapacity
+	if o.capacity > 0 {
+		// Size the policy segments for a fair share of the budget.
+		shardCapacity = (o.capacity + len(shards) - 1) / len(shards)
+	}
+	for _, shard := range shards {
+		shard.capacity = shardCapacity
+		shard.budget = m.budget
+		shard.policy = newEvictionPolicy[K](o.policy, shardCapacity)
+	}
+}
+
+// bounded reports whether the map has an eviction policy.
+func (m *Map[K, V]) bounded() bool {
+	return m.opts.capacity > 0 || m.opts.shardCapacity > 0
+}
+
+func newEvictionPolicy[K comparable](p EvictionPolicy, capacity int) evictionPolicy[K] {
+	switch p {
+	case LRU:
+		return newLRUPolicy[K]()
+	case LFU:
+		return newLFUPolicy[K]()
+	case TinyLFU:
+		return newTinyLFUPolicy[K](capacity)
+	}
+	panic(fmt.Sprintf("cmap: unknown eviction policy %d", int(p)))
+}
+
+// getTracked is Get for bounded maps, recording the access for the policy.
+// hash is the sharding hash of key.
+func (m *Map[K, V]) getTracked(hash uint32, key K) (V, bool) {
+	shard := m.lockHash(hash)
+	shard.countGet()
+	shard.purgeExpired(key)
+	val, ok := shard.items[key]
+	if ok {
+		shard.policy.access(key)
+	}
+	m.unlock(shard)
+	return val, ok
+}
+
+// overCapacity reports whether the shard, or the map for a global budget,
+// holds more entries than allowed. Write lock must be held.
+func (s *Shard[K, V]) overCapacity() bool {
+	if s.budget != nil {
+		return s.budget.used.Load() > s.budget.limit
+	}
+	return len(s.items) > s.capacity
+}
+
+// evictOverflow evicts entries until the shard is within capacity again.
+// inserted is the key just added, which only the admission filter may reject.
+// When the shard has nothing else to evict, the global budget is left to
+// evictOtherShards. Write lock must be held.
+func (s *Shard[K, V]) evictOverflow(inserted *K) {
+	for s.overCapacity() {
+		victim, reason, ok := s.policy.victim(inserted)
+		if !ok || inserted != nil && victim == *inserted && reason != EvictRejected {
+			s.overBudget = s.budget != nil
+			return
+		}
+		v, ok := s.drop(victim, OpEvict)
+		if s.walErr != nil {
+			return
+		}
+		if ok && s.owner.onEvict != nil {
+			s.pending = append(s.pending, notification[K, V]{key: victim, val: v, reason: reason})
+		}
+	}
+}
+
+// evictOtherShards evicts from the shards of the map, one after the other,
+// until the global budget is met again. It runs once the lock of the shard
+// that could not make room is released.
+func (m *Map[K, V]) evictOtherShards() {
+	shards := m.pinShards()
+	defer m.unpinShards()
+	for range shards {
+		if m.budget.used.Load() <= m.budget.limit {
+			return
+		}
+		shard := shards[m.budget.next.Add(1)%uint64(len(shards))]
+		shard.Lock()
+		shard.evictOverflow(nil)
+		shard.overBudget = false
+		m.unlock(shard)
+	}
+}
+
+// evictionPolicy keeps the metadata of a shard's entries needed to pick
+// eviction victims. It is guarded by the shard lock.
+type evictionPolicy[K comparable] interface {
+	add(key K)
+	access(key K)
+	remove(key K)
+	// victim returns the entry to evict. LRU and LFU never pick exclude,
+	// if it is not nil.
+	victim(exclude *K) (K, EvictReason, bool)
+}
+
+// lruNode is an element of an lruList.
+type lruNode[K comparable] struct {
+	key        K
+	prev, next *lruNode[K]
+	list       *lruList[K]
+}
+
+// lruList is a doubly linked list ordered from most to least recently used.
+type lruList[K comparable] struct {
+	root lruNode[K]
+	len  int
+}
+
+func (l *lruList[K]) init() {
+	l.root.prev, l.root.next = &l.root, &l.root
+}
+
+func (l *lruList[K]) pushFront(n *lruNode[K]) {
+	n.prev, n.next = &l.root, l.root.next
+	l.root.next.prev = n
+	l.root.next = n
+	n.list = l
+	l.len++
+}
+
+func (l *lruList[K]) remove(n *lruNode[K]) {
+	n.prev.next = n.next
+	n.next.prev = n.prev
+	n.prev, n.next, n.list = nil, nil, nil
+	l.len--
+}
+
+func (l *lruList[K]) moveToFront(n *lruNode[K]) {
+	l.remove(n)
+	l.pushFront(n)
+}
+
+// back returns the least recently used node, nil if the list is empty.
+func (l *lruList[K]) back() *lruNode[K] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
+
+type lruPolicy[K comparable] struct {
+	nodes map[K]*lruNode[K]
+	list  lruList[K]
+}
+
+func newLRUPolicy[K comparable]() *lruPolicy[K] {
+	p := &lruPolicy[K]{nodes: make(map[K]*lruNode[K])}
+	p.list.init()
+	return p
+}
+
+func (p *lruPolicy[K]) add(key K) {
+	n := &lruNode[K]{key: key}
+	p.list.pushFront(n)
+	p.nodes[key] = n
+}
+
+func (p *lruPolicy[K]) access(key K) {
+	if n, ok := p.nodes[key]; ok {
+		p.list.moveToFront(n)
+	}
+}
+
+func (p *lruPolicy[K]) remove(key K) {
+	if n, ok := p.nodes[key]; ok {
+		p.list.remove(n)
+		delete(p.nodes, key)
+	}
+}
+
+func (p *lruPolicy[K]) victim(exclude *K) (K, EvictReason, bool) {
+	n := p.list.back()
+	if n != nil && exclude != nil && n.key == *exclude {
+		n = n.prev
+	}
+	if n == nil || n == &p.list.root {
+		return *new(K), EvictCapacity, false
+	}
+	return n.key, EvictCapacity, true
+}
+
+// lfuEntry is an element of an lfuHeap.
+type lfuEntry[K comparable] struct {
+	key   K
+	freq  uint64
+	tick  uint64 // last access, breaks ties between equal frequencies
+	index int
+}
+
+// lfuHeap is a min-heap of entries by frequency, then by last access.
+type lfuHeap[K comparable] []*lfuEntry[K]
+
+func (h lfuHeap[K]) Len() int { return len(h) }
+
+func (h lfuHeap[K]) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap[K]) Push(x interface{}) {
+	e := x.(*lfuEntry[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap[K]) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+type lfuPolicy[K comparable] struct {
+	entries map[K]*lfuEntry[K]
+	heap    lfuHeap[K]
+	tick    uint64
+}
+
+func newLFUPolicy[K comparable]() *lfuPolicy[K] {
+	return &lfuPolicy[K]{entries: make(map[K]*lfuEntry[K])}
+}
+
+func (p *lfuPolicy[K]) add(key K) {
+	p.tick++
+	e := &lfuEntry[K]{key: key, freq: 1, tick: p.tick}
+	heap.Push(&p.heap, e)
+	p.entries[key] = e
+}
+
+func (p *lfuPolicy[K]) access(key K) {
+	if e, ok := p.entries[key]; ok {
+		p.tick++
+		e.freq++
+		e.tick = p.tick
+		heap.Fix(&p.heap, e.index)
+	}
+}
+
+func (p *lfuPolicy[K]) remove(key K) {
+	if e, ok := p.entries[key]; ok {
+		heap.Remove(&p.heap, e.index)
+		delete(p.entries, key)
+	}
+}
+
+func (p *lfuPolicy[K]) victim(exclude *K) (K, EvictReason, bool) {
+	if len(p.heap) == 0 {
+		return *new(K), EvictCapacity, false
+	}
+	if exclude == nil || p.heap[0].key != *exclude {
+		return p.heap[0].key, EvictCapacity, true
+	}
+	// The root is excluded, the next smallest is one of its children.
+	best := -1
+	for _, i := range []int{1, 2} {
+		if i < len(p.heap) && (best < 0 || p.heap.Less(i, best)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return *new(K), EvictCapacity, false
+	}
+	return p.heap[best].key, EvictCapacity, true
+}
+
+// tinyLFUPolicy implements W-TinyLFU: a window LRU (1% of capacity) in front
+// of a segmented LRU split into probation (20%) and protected (80%) segments.
+// Entries leaving the window compete with the probation victim and are only
+// admitted if the frequency sketch says they are used more often.
+type tinyLFUPolicy[K comparable] struct {
+	nodes        map[K]*lruNode[K]
+	window       lruList[K]
+	probation    lruList[K]
+	protected    lruList[K]
+	windowCap    int
+	mainCap      int
+	protectedCap int
+	sketch       *countMinSketch
+	hash         func(key K) uint32
+}
+
+// newTinyLFUPolicy creates a TinyLFU policy. Its sketch hashes every key
+// with defaultSharding, whatever places the keys in shards, since prefix or
+// custom sharding give many keys the same hash.
+func newTinyLFUPolicy[K comparable](capacity int) *tinyLFUPolicy[K] {
+	windowCap := capacity / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	mainCap := capacity - windowCap
+	if mainCap < 1 {
+		mainCap = 1
+	}
+	p := &tinyLFUPolicy[K]{
+		nodes:        make(map[K]*lruNode[K]),
+		windowCap:    windowCap,
+		mainCap:      mainCap,
+		protectedCap: mainCap * 8 / 10,
+		sketch:       newCountMinSketch(capacity),
+		hash:         defaultSharding[K],
+	}
+	p.window.init()
+	p.probation.init()
+	p.protected.init()
+	return p
+}
+
+func (p *tinyLFUPolicy[K]) add(key K) {
+	p.sketch.increment(p.hash(key))
+	n := &lruNode[K]{key: key}
+	p.window.pushFront(n)
+	p.nodes[key] = n
+}
+
+func (p *tinyLFUPolicy[K]) access(key K) {
+	p.sketch.increment(p.hash(key))
+	n, ok := p.nodes[key]
+	if !ok {
+		return
+	}
+	switch n.list {
+	case &p.probation:
+		// A second hit promotes the entry to the protected segment.
+		p.probation.remove(n)
+		p.protected.pushFront(n)
+		for p.protected.len > p.protectedCap {
+			demoted := p.protected.back()
+			p.protected.remove(demoted)
+			p.probation.pushFront(demoted)
+		}
+	default:
+		n.list.moveToFront(n)
+	}
+}
+
+func (p *tinyLFUPolicy[K]) remove(key K) {
+	if n, ok := p.nodes[key]; ok {
+		n.list.remove(n)
+		delete(p.nodes, key)
+	}
+}
+
+// mainVictim returns the least recently used entry of the main segments.
+func (p *tinyLFUPolicy[K]) mainVictim() *lruNode[K] {
+	if n := p.probation.back(); n != nil {
+		return n
+	}
+	return p.protected.back()
+}
+
+func (p *tinyLFUPolicy[K]) victim(*K) (K, EvictReason, bool) {
+	for p.window.len > p.windowCap {
+		candidate := p.window.back()
+		victim := p.mainVictim()
+		if victim == nil || p.probation.len+p.protected.len < p.mainCap {
+			// Room left in the main segments, admit without a contest.
+			p.window.remove(candidate)
+			p.probation.pushFront(candidate)
+			continue
+		}
+		if p.sketch.estimate(p.hash(candidate.key)) > p.sketch.estimate(p.hash(victim.key)) {
+			p.window.remove(candidate)
+			p.probation.pushFront(candidate)
+			return victim.key, EvictCapacity, true
+		}
+		return candidate.key, EvictRejected, true
+	}
+	if n := p.mainVictim(); n != nil {
+		return n.key, EvictCapacity, true
+	}
+	if n := p.window.back(); n != nil {
+		return n.key, EvictCapacity, true
+	}
+	return *new(K), EvictCapacity, false
+}
+
+// countMinSketch estimates access frequencies with 4 rows of 4 bit counters
+// (stored in bytes), 4 counters per entry of capacity. All counters are halved
+// every 10 * width increments, so the estimates follow recent popularity.
+type countMinSketch struct {
+	rows      [4][]uint8
+	mask      uint32
+	additions int
+	resetAt   int
+}
+
+var sketchSeeds = [4]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < 4*capacity {
+		width <<= 1
+	}
+	c := &countMinSketch{mask: uint32(width - 1), resetAt: 10 * width}
+	for i := range c.rows {
+		c.rows[i] = make([]uint8, width)
+	}
+	return c
+}
+
+func (c *countMinSketch) index(hash uint32, row int) uint32 {
+	return mix64(uint64(hash)^sketchSeeds[row]) & c.mask
+}
+
+func (c *countMinSketch) increment(hash uint32) {
+	for i := range c.rows {
+		if idx := c.index(hash, i); c.rows[i][idx] < 15 {
+			c.rows[i][idx]++
+		}
+	}
+	c.additions++
+	if c.additions >= c.resetAt {
+		for i := range c.rows {
+			for j := range c.rows[i] {
+				c.rows[i][j] >>= 1
+			}
+		}
+		c.additions /= 2
+	}
+}
+
+func (c *countMinSketch) estimate(hash uint32) uint8 {
+	min := uint8(15)
+	for i := range c.rows {
+		if v := c.rows[i][c.index(hash, i)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
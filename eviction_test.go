@@ -0,0 +1,201 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+type evicted struct {
+	key    string
+	reason EvictReason
+}
+
+func newBounded(t *testing.T, policy EvictionPolicy, capacity int, log *[]evicted) *Map[string, int] {
+	return NewWithOptions[string, int](
+		WithShardCount(1),
+		WithShardCapacity(capacity),
+		WithEvictionPolicy(policy),
+		WithOnEvict(func(key string, v int, reason EvictReason) {
+			*log = append(*log, evicted{key, reason})
+		}),
+	)
+}
+
+func TestLRUEviction(t *testing.T) {
+	var log []evicted
+	m := newBounded(t, LRU, 3, &log)
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3)
+	m.Get("a")
+	m.Set("d", 4)
+
+	if len(log) != 1 || log[0] != (evicted{"b", EvictCapacity}) {
+		t.Error("LRU should evict b, got", log)
+	}
+	if m.Count() != 3 || !m.Has("a") || !m.Has("d") {
+		t.Error("a and d should stay in the map.")
+	}
+}
+
+func TestLFUEviction(t *testing.T) {
+	var log []evicted
+	m := newBounded(t, LFU, 2, &log)
+	m.Set("a", 1)
+	for i := 0; i < 3; i++ {
+		m.Get("a")
+	}
+	m.Set("b", 2)
+	m.Set("c", 3)
+
+	if len(log) != 1 || log[0].key != "b" {
+		t.Error("LFU should evict b, got", log)
+	}
+	if !m.Has("a") || !m.Has("c") {
+		t.Error("the new entry and the frequent one should stay in the map.")
+	}
+}
+
+func TestTinyLFUKeepsHotEntries(t *testing.T) {
+	var log []evicted
+	m := newBounded(t, TinyLFU, 100, &log)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 100; i++ {
+			m.Set("hot"+strconv.Itoa(i), i)
+			m.Get("hot" + strconv.Itoa(i))
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		m.Set("cold"+strconv.Itoa(i), i)
+	}
+
+	if m.Count() != 100 {
+		t.Error("Expecting the map to stay at capacity, got", m.Count())
+	}
+	hot := 0
+	for i := 0; i < 100; i++ {
+		if m.Has("hot" + strconv.Itoa(i)) {
+			hot++
+		}
+	}
+	if hot < 90 {
+		t.Error("TinyLFU should keep the hot entries, kept", hot)
+	}
+	rejected := 0
+	for _, e := range log {
+		if e.reason == EvictRejected {
+			rejected++
+		}
+	}
+	if rejected == 0 {
+		t.Error("cold entries should be rejected by the admission filter.")
+	}
+}
+
+func TestTinyLFUPrefixSharding(t *testing.T) {
+	// Every key shares its sharding hash, the sketch must still tell them apart.
+	m := NewWithOptions[string, int](WithShardCount(1), WithShardCapacity(100),
+		WithEvictionPolicy(TinyLFU), WithPrefixSharding("/", 1))
+	for i := 0; i < 100; i++ {
+		m.Set("tenant/cold"+strconv.Itoa(i), i)
+	}
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			m.Set("tenant/hot"+strconv.Itoa(i), i)
+		}
+	}
+	hot := 0
+	for i := 0; i < 50; i++ {
+		if m.Has("tenant/hot" + strconv.Itoa(i)) {
+			hot++
+		}
+	}
+	if hot < 45 {
+		t.Error("TinyLFU should admit the frequent entries of a prefix, kept", hot)
+	}
+}
+
+func TestGlobalCapacity(t *testing.T) {
+	evictions := 0
+	m := NewWithOptions[string, int](
+		WithShardCount(4),
+		WithCapacity(50),
+		WithOnEvict(func(key string, v int, reason EvictReason) { evictions++ }),
+	)
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	if m.Count() != 50 {
+		t.Error("Expecting 50 elements, got", m.Count())
+	}
+	if evictions != 950 {
+		t.Error("Expecting 950 evictions, got", evictions)
+	}
+
+	// Removing entries gives the budget back.
+	for _, key := range m.Keys()[:10] {
+		m.Remove(key)
+	}
+	for i := 1000; i < 1010; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	if evictions != 950 || m.Count() != 50 {
+		t.Error("removed entries should free their share of the budget.")
+	}
+}
+
+func TestGlobalCapacityBelowShardCount(t *testing.T) {
+	for _, policy := range []EvictionPolicy{LRU, LFU, TinyLFU} {
+		m := NewWithOptions[string, int](
+			WithShardCount(32),
+			WithCapacity(4),
+			WithEvictionPolicy(policy),
+		)
+		for i := 0; i < 1000; i++ {
+			m.Set(strconv.Itoa(i), i)
+		}
+		if m.Count() != 4 {
+			t.Error("shards holding only the new entry should evict from the others.", policy, m.Count())
+		}
+		if !m.Has("999") {
+			t.Error("the new entry should be kept.", policy)
+		}
+
+		// A transaction holds several shards, it evicts once all are released.
+		keys := []string{"a", "b", "c", "d", "e", "f"}
+		m.Atomically(keys, func(tx Tx[string, int]) error {
+			for _, key := range keys {
+				tx.Set(key, 0)
+			}
+			return nil
+		})
+		if m.Count() != 4 {
+			t.Error("transactions should stay within the budget.", policy, m.Count())
+		}
+	}
+}
+
+func TestEvictionWithTTL(t *testing.T) {
+	now := fakeClock(t)
+	var log []evicted
+	m := newBounded(t, LRU, 2, &log)
+	m.SetWithTTL("a", 1, time.Second)
+	m.Set("b", 2)
+	*now += int64(time.Second)
+	m.DeleteExpired()
+	m.Set("c", 3)
+
+	if len(log) != 0 {
+		t.Error("expired entries should not count against the capacity.", log)
+	}
+}
+
+func TestEvictionOptionsMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("a mismatching OnEvict callback should panic.")
+		}
+	}()
+	NewWithOptions[string, int](WithOnEvict(func(key int, v int, reason EvictReason) {}))
+}
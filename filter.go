@@ -0,0 +1,84 @@
+package cmap
+
+// RemoveIf removes the entries for which pred returns true and returns how
+// many it removed. Each shard is filtered under its write lock, so pred sees
+// every entry once and must not use the map. Expired entries are expired
+// first, as DeleteExpired would.
+func (m *Map[K, V]) RemoveIf(pred func(key K, v V) bool) int {
+	return m.removeWhere(pred, true)
+}
+
+// Retain removes the entries for which keep returns false and returns how
+// many it removed, see RemoveIf.
+func (m *Map[K, V]) Retain(keep func(key K, v V) bool) int {
+	return m.removeWhere(keep, false)
+}
+
+// removeWhere removes the entries for which pred returns match.
+func (m *Map[K, V]) removeWhere(pred func(key K, v V) bool, match bool) int {
+	removed := 0
+	shards := m.pinShards()
+	defer m.unpinShards()
+	for _, shard := range shards {
+		shard.Lock()
+		shard.purgeAllExpired()
+		for key, v := range shard.items {
+			if pred(key, v) == match {
+				if _, ok := shard.drop(key, OpRemove); ok {
+					removed++
+				}
+			}
+		}
+		m.unlock(shard)
+	}
+	return removed
+}
+
+// Clear removes every entry and returns how many it removed. The maps of
+// the shards are replaced rather than emptied, so their memory is freed.
+// Expired entries are expired first, as DeleteExpired would.
+func (m *Map[K, V]) Clear() int {
+	removed := 0
+	shards := m.pinShards()
+	defer m.unpinShards()
+	for _, shard := range shards {
+		shard.Lock()
+		removed += shard.clear()
+		m.unlock(shard)
+	}
+	return removed
+}
+
+// clear removes every entry of the shard and returns how many it removed.
+// Write lock must be held.
+func (s *Shard[K, V]) clear() int {
+	s.purgeAllExpired()
+	n := len(s.items)
+	m := s.owner
+	if m.wal != nil || m.watchers.Load() != nil || m.indexes.Load() != nil {
+		// Log, report and unindex the entries one by one.
+		for key := range s.items {
+			s.drop(key, OpRemove)
+			if s.walErr != nil {
+				return n - len(s.items)
+			}
+		}
+	} else {
+		if st := s.stats; st != nil {
+			st.ops[OpRemove].Add(uint64(n))
+		}
+		if s.budget != nil {
+			s.budget.used.Add(int64(-n))
+		}
+	}
+	s.items = make(map[K]V)
+	s.expires = nil
+	s.dirty = true
+	if s.index != nil {
+		s.index = newSkipList(m.keyCmp)
+	}
+	if s.policy != nil {
+		s.policy = newEvictionPolicy[K](m.opts.policy, s.capacity)
+	}
+	return n
+}
@@ -0,0 +1,74 @@
+package cmap
+
+import (
+	"cmp"
+	"testing"
+	"time"
+)
+
+func TestRemoveIf(t *testing.T) {
+	m := NewMap[int, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	if n := m.RemoveIf(func(key, v int) bool { return v%2 == 0 }); n != 50 {
+		t.Error("RemoveIf should report the removed entries.", n)
+	}
+	if n := m.Retain(func(key, v int) bool { return v < 51 }); n != 25 {
+		t.Error("Retain should report the removed entries.", n)
+	}
+	if m.Count() != 25 || !m.Has(1) || m.Has(2) || m.Has(51) {
+		t.Error("only matching entries should be removed.", m.Count())
+	}
+}
+
+func TestRemoveIfExpired(t *testing.T) {
+	now := fakeClock(t)
+	expired := 0
+	m := NewWithOptions[string, int](WithOnExpire(func(string, int) { expired++ }))
+	m.SetWithTTL("a", 1, time.Second)
+	m.Set("b", 2)
+	*now += int64(time.Second)
+	if n := m.RemoveIf(func(string, int) bool { return true }); n != 1 || expired != 1 {
+		t.Error("expired entries should be expired, not removed.", n, expired)
+	}
+}
+
+func TestClear(t *testing.T) {
+	m := NewWithOptions[int, int](WithCapacity(100), WithMetrics(), WithKeyOrder(cmp.Compare[int]))
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	if n := m.Clear(); n != 100 {
+		t.Error("Clear should report the removed entries.", n)
+	}
+	if !m.IsEmpty() || len(m.RangeKeys(0, 100)) != 0 {
+		t.Error("Clear should remove every entry.")
+	}
+	if m.Stats().Total.Removes != 100 {
+		t.Error("Clear should be counted.", m.Stats().Total.Removes)
+	}
+	// The budget is released.
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	if m.Count() != 100 {
+		t.Error("Clear should release the capacity.", m.Count())
+	}
+}
+
+func TestClearWatchAndIndex(t *testing.T) {
+	m := NewMap[string, int]()
+	m.AddIndex("all", func(int) []string { return []string{"all"} })
+	w := m.Watch("a")
+	defer w.Close()
+	m.Set("a", 1)
+	nextEvent(t, w)
+	m.Clear()
+	if e := nextEvent(t, w); e.Op != OpRemove || e.Old != 1 {
+		t.Error("Clear should be reported to watchers.", e)
+	}
+	if len(m.LookupIndex("all", "all")) != 0 || m.indexList()["all"].keys.Count() != 0 {
+		t.Error("Clear should update the indexes.")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build go1.24
+
+package cmap
+
+import "hash/maphash"
+
+// comparableSeed is shared by all maps, so that maps with the same options
+// place keys alike, see Merge.
+var comparableSeed = maphash.MakeSeed()
+
+// hashComparable hashes key consistently with ==: pointers by address,
+// interfaces by dynamic type and value, structs and arrays field by field.
+func hashComparable[K comparable](key K) uint32 {
+	return fold64(maphash.Comparable(comparableSeed, key))
+}
@@ -0,0 +1,61 @@
+//go:build !go1.24
+
+package cmap
+
+import "reflect"
+
+// hashComparable hashes key consistently with ==: pointers by address,
+// interfaces by dynamic type and value, structs and arrays field by field.
+// maphash.Comparable does this from Go 1.24 on.
+func hashComparable[K comparable](key K) uint32 {
+	return mix64(hashValue(reflect.ValueOf(&key).Elem(), 14695981039346656037))
+}
+
+// hashValue folds v into h.
+func hashValue(v reflect.Value, h uint64) uint64 {
+	mix := func(x uint64) uint64 {
+		return (h ^ x) * 1099511628211
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return mix(1)
+		}
+		return mix(0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return mix(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return mix(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return mix(uint64(hashFloat(v.Float())))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		h = mix(uint64(hashFloat(real(c))))
+		return mix(uint64(hashFloat(imag(c))))
+	case reflect.String:
+		return mix(uint64(fnv32(v.String())))
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return mix(uint64(v.Pointer()))
+	case reflect.Interface:
+		if v.IsNil() {
+			return mix(0)
+		}
+		e := v.Elem()
+		h = mix(uint64(fnv32(e.Type().String())))
+		return hashValue(e, h)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			h = hashValue(v.Index(i), h)
+		}
+		return h
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).Name != "_" { // blank fields are left out of ==
+				h = hashValue(v.Field(i), h)
+			}
+		}
+		return h
+	}
+	return h
+}
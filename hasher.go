@@ -0,0 +1,246 @@
+package cmap
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+	"unsafe"
+)
+
+// Hasher computes shard hashes for string keys. Hash and HashBytes must
+// return the same value for a key and its []byte form, so that the *Bytes
+// methods find the shard the string methods use.
+type Hasher interface {
+	Hash(key string) uint32
+	HashBytes(key []byte) uint32
+}
+
+// FNV32 is the default Hasher of string keyed maps.
+var FNV32 Hasher = fnvHasher{}
+
+type fnvHasher struct{}
+
+func (fnvHasher) Hash(key string) uint32 {
+	return fnv32(key)
+}
+
+func (fnvHasher) HashBytes(key []byte) uint32 {
+	return fnv32Bytes(key)
+}
+
+// seededHasher is maphash with a seed of its own, so shard placement can not
+// be predicted (and flooded) from outside the process.
+type seededHasher struct {
+	seed maphash.Seed
+}
+
+// NewSeededHasher returns a maphash based Hasher with a fresh random seed.
+// Give every map its own seeded hasher to protect against hash-flooding.
+func NewSeededHasher() Hasher {
+	return seededHasher{seed: maphash.MakeSeed()}
+}
+
+func (h seededHasher) Hash(key string) uint32 {
+	return fold64(maphash.String(h.seed, key))
+}
+
+func (h seededHasher) HashBytes(key []byte) uint32 {
+	return fold64(maphash.Bytes(h.seed, key))
+}
+
+// HasherFunc adapts a byte hashing function (xxhash, crc32, ...) to Hasher.
+// String keys are passed without copying, so fn must not retain or modify
+// its argument.
+type HasherFunc func(key []byte) uint32
+
+func (fn HasherFunc) Hash(key string) uint32 {
+	return fn(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+func (fn HasherFunc) HashBytes(key []byte) uint32 {
+	return fn(key)
+}
+
+// WithHasher sets the Hasher of a string keyed map.
+// NewWithOptions panics if the map keys are not strings.
+func WithHasher(h Hasher) Option {
+	return func(o *options) {
+		o.hasher = h
+	}
+}
+
+// WithShardingFunc sets the function used to pick the shard of a key, for
+// maps of any key type. NewWithOptions panics if K does not match the map or
+// if it is combined with WithAutoGrow.
+func WithShardingFunc[K comparable](fn func(key K) uint32) Option {
+	return func(o *options) {
+		o.sharding = fn
+	}
+}
+
+// shardingFromOptions resolves the sharding function and, for string keyed
+// maps, the Hasher of a new map.
+func shardingFromOptions[K comparable](o *options) (func(key K) uint32, Hasher) {
+	if o.prefixSegments != 0 || o.prefixSep != "" {
+		if o.prefixSegments <= 0 || o.prefixSep == "" {
+			panic("cmap: WithPrefixSharding needs a separator and a positive segment count")
+		}
+		if o.sharding != nil {
+			panic("cmap: WithPrefixSharding can not be combined with WithShardingFunc")
+		}
+	}
+	if o.sharding != nil {
+		fn, ok := o.sharding.(func(key K) uint32)
+		if !ok {
+			panic(fmt.Sprintf("cmap: sharding function %T does not match key type", o.sharding))
+		}
+		return fn, nil
+	}
+	var zero K
+	if _, ok := any(zero).(string); !ok {
+		if o.hasher != nil {
+			panic("cmap: WithHasher requires string keys")
+		}
+		if o.prefixSegments != 0 {
+			panic("cmap: WithPrefixSharding requires string keys")
+		}
+		return defaultSharding[K], nil
+	}
+	h := o.hasher
+	if h == nil {
+		h = FNV32
+	}
+	if o.prefixSegments != 0 {
+		h = prefixHasher{h: h, sep: o.prefixSep, sepBytes: []byte(o.prefixSep), segments: o.prefixSegments}
+	}
+	fn := func(key string) uint32 {
+		return h.Hash(key)
+	}
+	return any(fn).(func(key K) uint32), h
+}
+
+// hashBytes returns the sharding hash of a key of a string keyed map.
+func (m *Map[K, V]) hashBytes(key []byte) uint32 {
+	if m.hasher != nil {
+		return m.hasher.HashBytes(key)
+	}
+	// A custom sharding function only takes strings.
+	return m.sharding(any(string(key)).(K))
+}
+
+// stringItems returns the items of a shard of a string keyed map.
+func stringItems[K comparable, V any](shard *Shard[K, V]) map[string]V {
+	items, ok := any(shard.items).(map[string]V)
+	if !ok {
+		panic("cmap: *Bytes methods require string keys")
+	}
+	return items
+}
+
+// GetBytes retrieves an element from a string keyed map without converting
+// key to a string. It panics if the map keys are not strings.
+func (m *Map[K, V]) GetBytes(key []byte) (V, bool) {
+	hash := m.hashBytes(key)
+	if m.bounded() {
+		return m.getTracked(hash, any(string(key)).(K))
+	}
+	shard := m.rlockHash(hash)
+	shard.countGet()
+	// The string conversion in a map index does not allocate.
+	val, ok := stringItems(shard)[string(key)]
+	expired := false
+	if ok && len(shard.expires) > 0 {
+		deadline, hasTTL := any(shard.expires).(map[string]int64)[string(key)]
+		expired = hasTTL && deadline <= nowNano()
+	}
+	shard.RUnlock()
+	if expired {
+		m.expireKey(any(string(key)).(K))
+		return *new(V), false
+	}
+	return val, ok
+}
+
+// SetBytes sets the given value under key in a string keyed map.
+// It panics if the map keys are not strings.
+func (m *Map[K, V]) SetBytes(key []byte, value V) {
+	k, ok := any(string(key)).(K)
+	if !ok {
+		panic("cmap: *Bytes methods require string keys")
+	}
+	shard := m.lockHash(m.hashBytes(key))
+	shard.store(k, value, OpSet)
+	shard.clearTTL(k)
+	m.unlock(shard)
+}
+
+// defaultSharding hashes keys of any comparable type consistently with ==.
+// Strings keep using fnv32 so string keyed maps distribute exactly as before.
+func defaultSharding[K comparable](key K) uint32 {
+	switch k := any(key).(type) {
+	case string:
+		return fnv32(k)
+	case int:
+		return mix64(uint64(k))
+	case int8:
+		return mix64(uint64(k))
+	case int16:
+		return mix64(uint64(k))
+	case int32:
+		return mix64(uint64(k))
+	case int64:
+		return mix64(uint64(k))
+	case uint:
+		return mix64(uint64(k))
+	case uint8:
+		return mix64(uint64(k))
+	case uint16:
+		return mix64(uint64(k))
+	case uint32:
+		return mix64(uint64(k))
+	case uint64:
+		return mix64(k)
+	case uintptr:
+		return mix64(uint64(k))
+	case float32:
+		return hashFloat(float64(k))
+	case float64:
+		return hashFloat(k)
+	default:
+		return hashComparable(key)
+	}
+}
+
+// hashFloat hashes a float so that 0 and -0, which are equal, agree.
+func hashFloat(f float64) uint32 {
+	if f == 0 {
+		f = 0
+	}
+	return mix64(math.Float64bits(f))
+}
+
+// mix64 folds an integer key into 32 bits (splitmix64 finalizer),
+// so sequential ids do not pile up in neighbouring shards.
+func mix64(x uint64) uint32 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return uint32(x)
+}
+
+func fnv32Bytes(key []byte) uint32 {
+	hash := uint32(2166136261)
+	const prime32 = uint32(16777619)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
+
+// fold64 xors the halves of a 64 bit hash together.
+func fold64(x uint64) uint32 {
+	return uint32(x ^ x>>32)
+}
@@ -0,0 +1,137 @@
+package cmap
+
+import (
+	"bytes"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestFnv32Bytes(t *testing.T) {
+	for _, key := range []string{"", "ABC", "tenant/123/session/abc"} {
+		if fnv32(key) != fnv32Bytes([]byte(key)) {
+			t.Errorf("fnv32 and fnv32Bytes differ for %q", key)
+		}
+	}
+}
+
+func TestGetSetBytes(t *testing.T) {
+	hashers := map[string]Hasher{
+		"fnv":    FNV32,
+		"seeded": NewSeededHasher(),
+		"crc32":  HasherFunc(crc32.ChecksumIEEE),
+	}
+	for name, h := range hashers {
+		m := NewWithOptions[string, int](WithHasher(h))
+		for i := 0; i < 100; i++ {
+			m.SetBytes([]byte(strconv.Itoa(i)), i)
+		}
+		for i := 0; i < 100; i++ {
+			if v, ok := m.Get(strconv.Itoa(i)); !ok || v != i {
+				t.Errorf("%s: Get can not find key set by SetBytes %d", name, i)
+			}
+			if v, ok := m.GetBytes([]byte(strconv.Itoa(i))); !ok || v != i {
+				t.Errorf("%s: GetBytes can not find key %d", name, i)
+			}
+		}
+		if _, ok := m.GetBytes([]byte("missing")); ok {
+			t.Errorf("%s: GetBytes found a missing key", name)
+		}
+	}
+}
+
+func TestGetBytesNoAlloc(t *testing.T) {
+	m := NewWithOptions[string, int](WithHasher(NewSeededHasher()))
+	m.Set("tenant/123", 1)
+	key := []byte("tenant/123")
+	allocs := testing.AllocsPerRun(100, func() {
+		m.GetBytes(key)
+	})
+	if allocs != 0 {
+		t.Errorf("GetBytes allocated %v times per call", allocs)
+	}
+}
+
+func TestSeededHasherPerMap(t *testing.T) {
+	a, b := NewSeededHasher(), NewSeededHasher()
+	same := 0
+	for i := 0; i < 100; i++ {
+		if a.Hash(strconv.Itoa(i)) == b.Hash(strconv.Itoa(i)) {
+			same++
+		}
+	}
+	if same == 100 {
+		t.Error("seeded hashers should not agree on every key.")
+	}
+}
+
+func TestShardingFunc(t *testing.T) {
+	// Send every key to the first shard.
+	m := NewWithOptions[int, int](WithShardingFunc(func(key int) uint32 { return 0 }))
+	for i := 0; i < 10; i++ {
+		m.Set(i, i)
+	}
+	if len(m.table.Load().shards[0].items) != 10 {
+		t.Error("sharding function was not used.")
+	}
+
+	// A custom sharding function still serves the *Bytes methods.
+	s := NewWithOptions[string, int](WithShardingFunc(fnv32))
+	s.SetBytes([]byte("a"), 1)
+	if v, ok := s.Get("a"); !ok || v != 1 {
+		t.Error("SetBytes should use the sharding function.")
+	}
+}
+
+func TestHasherKeyTypeMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("WithHasher on an int keyed map should panic.")
+		}
+	}()
+	NewWithOptions[int, int](WithHasher(FNV32))
+}
+
+func TestShardingFuncKeyTypeMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("a string sharding function on an int keyed map should panic.")
+		}
+	}()
+	NewWithOptions[int, int](WithShardingFunc(fnv32))
+}
+
+func TestDefaultShardingAgreesWithEquality(t *testing.T) {
+	floats := NewMap[float64, int]()
+	floats.Set(0.0, 1)
+	floats.Set(math.Copysign(0, -1), 2)
+	if floats.Count() != 1 {
+		t.Error("0 and -0 are the same key.", floats.Count())
+	}
+
+	buf := &bytes.Buffer{}
+	pointers := NewMap[*bytes.Buffer, int]()
+	pointers.Set(buf, 1)
+	buf.WriteString("changed")
+	if !pointers.Has(buf) {
+		t.Error("pointer keys should be placed by address.")
+	}
+
+	type point struct {
+		X, Y float64
+		_    int
+	}
+	structs := NewMap[point, int]()
+	structs.Set(point{X: 0, Y: 1}, 1)
+	if v, ok := structs.Get(point{X: math.Copysign(0, -1), Y: 1}); !ok || v != 1 {
+		t.Error("struct keys should be placed consistently with ==.")
+	}
+
+	anys := NewMap[any, int]()
+	anys.Set(buf, 1)
+	anys.Set(0.0, 2)
+	if !anys.Has(buf) || !anys.Has(math.Copysign(0, -1)) {
+		t.Error("interface keys should be placed by dynamic value.")
+	}
+}
@@ -0,0 +1,108 @@
+package cmap
+
+import (
+	"fmt"
+	"slices"
+)
+
+// valueIndex maps the index keys extracted from values to the primary keys
+// holding them.
+type valueIndex[K comparable, V any] struct {
+	extract func(V) []string
+	keys    *NestedGSet // index key to the set of primary keys
+}
+
+// AddIndex adds a secondary index called name, mapping every entry to the
+// index keys extract returns for its value. The index covers the entries
+// already in the map and is updated with every write, under the lock of the
+// written shard, so extract must not use the map. It panics if an index
+// called name already exists.
+//
+// Writes through the nested map types of this package are not indexed.
+func (m *Map[K, V]) AddIndex(name string, extract func(V) []string) {
+	idx := &valueIndex[K, V]{extract: extract, keys: NewNestedGSet()}
+	m.indexMu.Lock()
+	old := m.indexList()
+	if _, ok := old[name]; ok {
+		m.indexMu.Unlock()
+		panic(fmt.Sprintf("cmap: index %q already exists", name))
+	}
+	indexes := make(map[string]*valueIndex[K, V], len(old)+1)
+	for n, i := range old {
+		indexes[n] = i
+	}
+	indexes[name] = idx
+	// Writes maintain the index from now on, the entries written before are
+	// added shard by shard under the write lock.
+	m.indexes.Store(&indexes)
+	m.indexMu.Unlock()
+
+	shards := m.pinShards()
+	defer m.unpinShards()
+	for _, shard := range shards {
+		shard.Lock()
+		for key, v := range shard.items {
+			idx.update(key, nil, extract(v))
+		}
+		shard.Unlock()
+	}
+}
+
+// indexList returns the indexes of the map, nil if it has none.
+func (m *Map[K, V]) indexList() map[string]*valueIndex[K, V] {
+	if p := m.indexes.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// update moves key from the index keys before to the index keys after.
+func (idx *valueIndex[K, V]) update(key K, before, after []string) {
+	for _, k := range before {
+		if !slices.Contains(after, k) {
+			idx.keys.DeleteValue(k, key)
+		}
+	}
+	for _, k := range after {
+		idx.keys.SetValue(k, key)
+	}
+}
+
+// reindex updates the indexes for a write of key. Write lock must be held.
+func (s *Shard[K, V]) reindex(key K, old V, existed bool, value V, deleted bool) {
+	for _, idx := range s.owner.indexList() {
+		var before, after []string
+		if existed {
+			before = idx.extract(old)
+		}
+		if !deleted {
+			after = idx.extract(value)
+		}
+		idx.update(key, before, after)
+	}
+}
+
+// LookupIndex returns the entries the index called name maps indexKey to,
+// in no particular order. Every entry returned is in the map with a value
+// indexed under indexKey at the time it is read. It panics if there is no
+// index called name.
+func (m *Map[K, V]) LookupIndex(name, indexKey string) []Entry[K, V] {
+	idx, ok := m.indexList()[name]
+	if !ok {
+		panic(fmt.Sprintf("cmap: no index %q", name))
+	}
+	keys, _ := idx.keys.GetValues(indexKey)
+	entries := make([]Entry[K, V], 0, len(keys))
+	for _, k := range keys {
+		key := k.(K)
+		shard := m.rlockKey(key)
+		v, ok := shard.items[key]
+		// A write may have changed the entry since the index was read.
+		ok = ok && !shard.isExpired(key) && slices.Contains(idx.extract(v), indexKey)
+		shard.RUnlock()
+		if ok {
+			entries = append(entries, Entry[K, V]{key, v})
+		}
+	}
+	return entries
+}
@@ -0,0 +1,121 @@
+package cmap
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type user struct {
+	Email string
+	Orgs  []string
+}
+
+func userKeys(entries []Entry[string, user]) []string {
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestIndex(t *testing.T) {
+	m := NewMap[string, user]()
+	m.Set("1", user{Email: "a@x", Orgs: []string{"x"}})
+	m.AddIndex("email", func(u user) []string { return []string{u.Email} })
+	m.AddIndex("org", func(u user) []string { return u.Orgs })
+	m.Set("2", user{Email: "b@x", Orgs: []string{"x", "y"}})
+
+	if e := m.LookupIndex("email", "a@x"); len(e) != 1 || e[0].Key != "1" || e[0].Val.Email != "a@x" {
+		t.Error("AddIndex should index the entries already in the map.", e)
+	}
+	if keys := userKeys(m.LookupIndex("org", "x")); !slices.Equal(keys, []string{"1", "2"}) {
+		t.Error("a value may have several index keys.", keys)
+	}
+
+	m.Upsert("2", user{}, func(exist bool, old, v user) user {
+		old.Orgs = []string{"y"}
+		return old
+	})
+	if keys := userKeys(m.LookupIndex("org", "x")); !slices.Equal(keys, []string{"1"}) {
+		t.Error("Upsert should update the index.", keys)
+	}
+	m.Pop("2")
+	if e := m.LookupIndex("org", "y"); len(e) != 0 {
+		t.Error("Pop should update the index.", e)
+	}
+	m.Remove("1")
+	if e := m.LookupIndex("email", "a@x"); len(e) != 0 {
+		t.Error("Remove should update the index.", e)
+	}
+	for _, name := range []string{"email", "org"} {
+		if m.indexList()[name].keys.Count() != 0 {
+			t.Error("empty index keys should be dropped.", name)
+		}
+	}
+}
+
+func TestIndexExpire(t *testing.T) {
+	now := fakeClock(t)
+	m := NewMap[string, user]()
+	m.AddIndex("email", func(u user) []string { return []string{u.Email} })
+	m.SetWithTTL("1", user{Email: "a@x"}, time.Second)
+	*now += int64(time.Second)
+	if e := m.LookupIndex("email", "a@x"); len(e) != 0 {
+		t.Error("expired entries should not be found.", e)
+	}
+	m.DeleteExpired()
+	if m.indexList()["email"].keys.Count() != 0 {
+		t.Error("expiry should update the index.")
+	}
+}
+
+func TestIndexConcurrent(t *testing.T) {
+	m := NewMap[int, int]()
+	m.AddIndex("parity", func(v int) []string { return []string{strconv.Itoa(v % 2)} })
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				m.Set(i%50, w+i)
+				for _, e := range m.LookupIndex("parity", "1") {
+					if e.Val%2 != 1 {
+						t.Error("LookupIndex returned an entry that does not match.", e)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	odd := 0
+	m.IterCb(func(k, v int) {
+		odd += v % 2
+	})
+	if n := len(m.LookupIndex("parity", "1")); n != odd {
+		t.Error("the index should agree with the map.", n, odd)
+	}
+}
+
+func TestIndexMisuse(t *testing.T) {
+	m := NewMap[string, int]()
+	m.AddIndex("a", func(int) []string { return nil })
+	for name, fn := range map[string]func(){
+		"duplicate": func() { m.AddIndex("a", func(int) []string { return nil }) },
+		"unknown":   func() { m.LookupIndex("b", "") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error(name, "index should panic.")
+				}
+			}()
+			fn()
+		}()
+	}
+}
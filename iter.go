@@ -0,0 +1,69 @@
+package cmap
+
+import (
+	"context"
+	"iter"
+)
+
+// All returns an iterator over all elements, for use with range.
+// Each shard is copied under its read lock and yielded after the lock is
+// released, so the loop body may use the map, and breaking out of the loop
+// leaves nothing locked and no goroutine behind. Resize waits for the loop
+// to end before moving entries.
+func (m *Map[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		var buf []Entry[K, V]
+		shards := m.pinShards()
+		defer m.unpinShards()
+		for _, shard := range shards {
+			buf = shard.appendLive(buf[:0])
+			for _, t := range buf {
+				if !yield(t.Key, t.Val) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// KeysSeq returns an iterator over all keys, see All.
+func (m *Map[K, V]) KeysSeq() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for key := range m.All() {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
+
+// RangeCtx calls fn for every element until fn returns false or ctx is
+// done, in which case it returns ctx.Err(). Like All, it holds no lock while
+// fn runs.
+func (m *Map[K, V]) RangeCtx(ctx context.Context, fn func(key K, v V) bool) error {
+	done := ctx.Done()
+	for key, v := range m.All() {
+		select {
+		case <-done:
+			return ctx.Err()
+		default:
+		}
+		if !fn(key, v) {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// appendLive appends the live elements of the shard to buf under its read lock.
+func (s *Shard[K, V]) appendLive(buf []Entry[K, V]) []Entry[K, V] {
+	s.RLock()
+	now := s.now()
+	for key, val := range s.items {
+		if !s.expiredAt(key, now) {
+			buf = append(buf, Entry[K, V]{key, val})
+		}
+	}
+	s.RUnlock()
+	return buf
+}
@@ -0,0 +1,90 @@
+package cmap
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	m := NewMap[string, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	seen := map[string]int{}
+	for key, v := range m.All() {
+		seen[key] = v
+	}
+	if len(seen) != 100 {
+		t.Error("Expecting 100 elements, got", len(seen))
+	}
+	keys := 0
+	for range m.KeysSeq() {
+		keys++
+	}
+	if keys != 100 {
+		t.Error("Expecting 100 keys, got", keys)
+	}
+}
+
+func TestAllBreak(t *testing.T) {
+	m := NewMap[string, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	goroutines := runtime.NumGoroutine()
+	counter := 0
+	for key := range m.All() {
+		// The loop body may write to the map.
+		m.Set(key, -1)
+		counter++
+		if counter == 42 {
+			break
+		}
+	}
+	if counter != 42 {
+		t.Error("We should have stopped at 42.")
+	}
+	if runtime.NumGoroutine() > goroutines {
+		t.Error("All should not start goroutines.")
+	}
+	// Every shard must be unlocked again.
+	for i := 100; i < 200; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	if m.Count() != 200 {
+		t.Error("Expecting 200 elements.")
+	}
+}
+
+func TestRangeCtx(t *testing.T) {
+	m := NewMap[string, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	counter := 0
+	err := m.RangeCtx(ctx, func(key string, v int) bool {
+		counter++
+		if counter == 10 {
+			cancel()
+		}
+		return true
+	})
+	if err != context.Canceled {
+		t.Error("RangeCtx should return the context error, got", err)
+	}
+	if counter != 10 {
+		t.Error("RangeCtx should stop right after cancellation, got", counter)
+	}
+
+	counter = 0
+	err = m.RangeCtx(context.Background(), func(key string, v int) bool {
+		counter++
+		return counter < 5
+	})
+	if err != nil || counter != 5 {
+		t.Error("RangeCtx should stop when fn returns false.")
+	}
+}
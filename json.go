@@ -0,0 +1,181 @@
+package cmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// DecodeFunc decodes the json value of an entry. It lets maps with interface
+// values, such as Map[string, interface{}], pick the concrete type of each value.
+type DecodeFunc[V any] func(data json.RawMessage) (V, error)
+
+// NewFromJSON creates a map configured by opts and fills it from the json
+// object in data, decoding values with decode, or with encoding/json into V
+// when decode is nil.
+func NewFromJSON[K comparable, V any](data []byte, decode DecodeFunc[V], opts ...Option) (*Map[K, V], error) {
+	m := NewWithOptions[K, V](opts...)
+	if err := m.DecodeJSON(json.NewDecoder(bytes.NewReader(data)), decode); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// UnmarshalJSON sets the entries of the json object in b, decoding values
+// into V with encoding/json. Keys follow the encoding/json rules for map keys.
+// A zero Map, such as a field of a struct being unmarshalled, gets the
+// default options first.
+func (m *Map[K, V]) UnmarshalJSON(b []byte) error {
+	return m.DecodeJSON(json.NewDecoder(bytes.NewReader(b)), nil)
+}
+
+// DecodeJSON reads one json object from dec and sets its entries one at a
+// time, so the object is never held in memory as a whole. Values are
+// decoded with decode, or with encoding/json into V when decode is nil.
+// On error the entries read so far stay in the map. A zero Map gets the
+// default options first, which is not safe for concurrent use.
+func (m *Map[K, V]) DecodeJSON(dec *json.Decoder, decode DecodeFunc[V]) error {
+	if m.table.Load() == nil {
+		m.init(defaultOptions())
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil // json null
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("cmap: expected json object, got %v", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, err := parseJSONKey[K](tok.(string))
+		if err != nil {
+			return err
+		}
+		var val V
+		if decode == nil {
+			err = dec.Decode(&val)
+		} else {
+			var raw json.RawMessage
+			if err = dec.Decode(&raw); err == nil {
+				val, err = decode(raw)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("cmap: decoding value of %q: %w", tok, err)
+		}
+		m.Set(key, val)
+	}
+	_, err = dec.Token() // closing '}'
+	return err
+}
+
+// EncodeJSON writes the map to w as a json object. Each shard is copied
+// under its read lock and encoded after the lock is released, so the output
+// is consistent per shard only. Keys are not sorted.
+func (m *Map[K, V]) EncodeJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('{')
+	var (
+		tuples []Entry[K, V]
+		buf    []byte
+		first  = true
+	)
+	shards := m.pinShards()
+	defer m.unpinShards()
+	for _, shard := range shards {
+		tuples = shard.appendLive(tuples[:0])
+		for _, t := range tuples {
+			buf = buf[:0]
+			if !first {
+				buf = append(buf, ',')
+			}
+			first = false
+			var err error
+			if buf, err = appendJSONKey(buf, t.Key); err != nil {
+				return err
+			}
+			buf = append(buf, ':')
+			val, err := json.Marshal(t.Val)
+			if err != nil {
+				return err
+			}
+			if _, err := bw.Write(append(buf, val...)); err != nil {
+				return err
+			}
+		}
+	}
+	bw.WriteByte('}')
+	return bw.Flush()
+}
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// appendJSONKey appends key as a quoted json object key, following the
+// encoding/json rules for map keys.
+func appendJSONKey[K comparable](buf []byte, key K) ([]byte, error) {
+	rv := reflect.ValueOf(key)
+	var s string
+	switch {
+	case !rv.IsValid():
+		return buf, errors.New("cmap: nil json key")
+	case rv.Kind() == reflect.String:
+		s = rv.String()
+	case rv.Type().Implements(textMarshalerType):
+		text, err := rv.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return buf, err
+		}
+		s = string(text)
+	case rv.CanInt():
+		s = strconv.FormatInt(rv.Int(), 10)
+	case rv.CanUint():
+		s = strconv.FormatUint(rv.Uint(), 10)
+	default:
+		return buf, fmt.Errorf("cmap: unsupported json key type %s", rv.Type())
+	}
+	quoted, err := json.Marshal(s)
+	return append(buf, quoted...), err
+}
+
+// parseJSONKey is the inverse of appendJSONKey.
+func parseJSONKey[K comparable](s string) (K, error) {
+	var key K
+	rv := reflect.ValueOf(&key).Elem()
+	switch {
+	case rv.Kind() == reflect.String:
+		rv.SetString(s)
+	case rv.Addr().Type().Implements(textUnmarshalerType):
+		err := rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		return key, err
+	case rv.CanInt():
+		n, err := strconv.ParseInt(s, 10, rv.Type().Bits())
+		if err != nil {
+			return key, err
+		}
+		rv.SetInt(n)
+	case rv.CanUint():
+		n, err := strconv.ParseUint(s, 10, rv.Type().Bits())
+		if err != nil {
+			return key, err
+		}
+		rv.SetUint(n)
+	default:
+		return key, fmt.Errorf("cmap: unsupported json key type %s", rv.Type())
+	}
+	return key, nil
+}
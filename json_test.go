@@ -0,0 +1,100 @@
+package cmap
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalJSON(t *testing.T) {
+	m := NewMap[string, account]()
+	if err := json.Unmarshal([]byte(`{"a":{"Owner":"ann","Balance":1},"b":{"Owner":"bob","Balance":2}}`), m); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := m.Get("b"); !ok || v.Owner != "bob" || v.Balance != 2 {
+		t.Error("UnmarshalJSON should decode typed values.", v)
+	}
+	if m.Count() != 2 {
+		t.Error("UnmarshalJSON should set every entry.")
+	}
+}
+
+func TestUnmarshalJSONZeroMap(t *testing.T) {
+	var doc struct {
+		Byname Map[string, int]
+		Byid   *Map[int, string]
+	}
+	if err := json.Unmarshal([]byte(`{"Byname":{"a":1,"b":2},"Byid":{"1":"a"}}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := doc.Byname.Get("b"); !ok || v != 2 || doc.Byname.Count() != 2 {
+		t.Error("a zero map field should be filled.", doc.Byname.Items())
+	}
+	if v, _ := doc.Byid.Get(1); v != "a" {
+		t.Error("a nil map pointer field should be filled.", v)
+	}
+	doc.Byname.Set("c", 3)
+	if doc.Byname.ShardCount() != SHARD_COUNT {
+		t.Error("a zero map should get the default options.")
+	}
+}
+
+func TestJSONRoundTripIntKeys(t *testing.T) {
+	m := NewMap[int, string]()
+	for i := -50; i < 50; i++ {
+		m.Set(i, strconv.Itoa(i))
+	}
+	j, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewFromJSON[int, string](j, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Count() != 100 {
+		t.Error("NewFromJSON should restore every entry.", n.Count())
+	}
+	if v, _ := n.Get(-7); v != "-7" {
+		t.Error("int keys should round trip.", v)
+	}
+}
+
+func TestNewFromJSONDecodeFunc(t *testing.T) {
+	decode := func(data json.RawMessage) (interface{}, error) {
+		var a account
+		err := json.Unmarshal(data, &a)
+		return a, err
+	}
+	m, err := NewFromJSON[string](
+		[]byte(`{"a":{"Owner":"ann","Balance":1}}`), DecodeFunc[interface{}](decode), WithShardCount(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Get("a"); v != (account{"ann", 1}) {
+		t.Error("NewFromJSON should decode values with the decode func.", v)
+	}
+	if m.ShardCount() != 4 {
+		t.Error("NewFromJSON should apply the options.")
+	}
+}
+
+func TestDecodeJSONStream(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(`{"a":1} {"b":2}`))
+	m := NewMap[string, int]()
+	for i := 0; i < 2; i++ {
+		if err := m.DecodeJSON(dec, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Count() != 2 {
+		t.Error("DecodeJSON should read one object per call.")
+	}
+	if err := m.DecodeJSON(json.NewDecoder(strings.NewReader(`[1]`)), nil); err == nil {
+		t.Error("DecodeJSON should reject non-objects.")
+	}
+	if err := m.DecodeJSON(json.NewDecoder(strings.NewReader(`{"c":"x"}`)), nil); err == nil {
+		t.Error("DecodeJSON should report badly typed values.")
+	}
+}
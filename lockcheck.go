@@ -0,0 +1,11 @@
+//go:build !cmapdebug
+
+package cmap
+
+import "sync"
+
+// assertLocked checks that mu is held, in builds with the cmapdebug tag.
+func assertLocked(mu *sync.RWMutex) {}
+
+// assertWriteLocked checks that mu is write locked, in builds with the cmapdebug tag.
+func assertWriteLocked(mu *sync.RWMutex) {}
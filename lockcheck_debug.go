@@ -0,0 +1,23 @@
+//go:build cmapdebug
+
+package cmap
+
+import "sync"
+
+// assertLocked panics if nobody holds mu. It can not tell which goroutine
+// holds the lock, so it catches calls made without any lock, not calls that
+// rely on a lock taken by someone else.
+func assertLocked(mu *sync.RWMutex) {
+	if mu.TryLock() {
+		mu.Unlock()
+		panic("cmap: NoLock method called without holding the shard lock")
+	}
+}
+
+// assertWriteLocked panics if mu is not write locked.
+func assertWriteLocked(mu *sync.RWMutex) {
+	if mu.TryRLock() {
+		mu.RUnlock()
+		panic("cmap: NoLock method called without holding the shard write lock")
+	}
+}
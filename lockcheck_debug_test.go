@@ -0,0 +1,36 @@
+//go:build cmapdebug
+
+package cmap
+
+import "testing"
+
+func TestNoLockWithoutLock(t *testing.T) {
+	m := NewMap[string, int]()
+	defer func() {
+		if recover() == nil {
+			t.Error("SetNoLock without the shard lock should panic in debug builds.")
+		}
+	}()
+	m.SetNoLock("a", 1)
+}
+
+func TestNoLockWithLock(t *testing.T) {
+	m := NewMap[string, int]()
+	shard := m.GetShard("a")
+	shard.Lock()
+	m.SetNoLock("a", 1)
+	shard.Unlock()
+	if !m.Has("a") {
+		t.Error("SetNoLock should set the value.")
+	}
+
+	g := NewNestedGSet()
+	g.SetValue("k", "v")
+	outer := g._cmap.GetShard("k")
+	outer.RLock()
+	values, _ := g.GetStrValuesNoLock("k")
+	outer.RUnlock()
+	if len(values) != 1 {
+		t.Error("GetStrValuesNoLock should read under the caller's lock.")
+	}
+}
@@ -0,0 +1,135 @@
+package cmap
+
+import "fmt"
+
+// LockedShard is a handle on a shard whose lock is held, given to the
+// callbacks of WithShard and ReadShard. It is only valid during the callback,
+// and only for keys that belong to the locked shard.
+type LockedShard[K comparable, V any] struct {
+	m     *Map[K, V]
+	shard *Shard[K, V]
+	write bool
+}
+
+// WithShard write locks the shard under given key and calls fn with a handle
+// on it. Every operation of the handle runs under that single lock.
+// fn must not call methods of the map itself for keys of the same shard.
+func (m *Map[K, V]) WithShard(key K, fn func(s *LockedShard[K, V])) {
+	shard := m.lockKey(key)
+	s := &LockedShard[K, V]{m: m, shard: shard, write: true}
+	defer func() {
+		s.shard = nil
+		m.unlock(shard)
+	}()
+	fn(s)
+}
+
+// ReadShard read locks the shard under given key and calls fn with a handle
+// on it. Write operations of the handle panic.
+func (m *Map[K, V]) ReadShard(key K, fn func(s *LockedShard[K, V])) {
+	shard := m.rlockKey(key)
+	s := &LockedShard[K, V]{m: m, shard: shard}
+	defer func() {
+		s.shard = nil
+		shard.RUnlock()
+	}()
+	fn(s)
+}
+
+// check panics unless the handle is live, allows the access and owns key.
+func (s *LockedShard[K, V]) check(key K, write bool) {
+	if s.shard == nil {
+		panic("cmap: LockedShard used after its callback returned")
+	}
+	if write && !s.write {
+		panic("cmap: write through a read locked shard")
+	}
+	if s.m.GetShard(key) != s.shard {
+		panic(fmt.Sprintf("cmap: key %v does not belong to the locked shard", key))
+	}
+}
+
+// Get retrieves an element under given key.
+func (s *LockedShard[K, V]) Get(key K) (V, bool) {
+	s.check(key, false)
+	if s.write {
+		s.shard.purgeExpired(key)
+	}
+	val, ok := s.shard.items[key]
+	if ok && s.shard.isExpired(key) {
+		return *new(V), false
+	}
+	if ok && s.write && s.shard.policy != nil {
+		s.shard.policy.access(key)
+	}
+	return val, ok
+}
+
+// Has looks up an item under given key.
+func (s *LockedShard[K, V]) Has(key K) bool {
+	s.check(key, false)
+	_, ok := s.shard.items[key]
+	return ok && !s.shard.isExpired(key)
+}
+
+// Set sets the given value under given key.
+func (s *LockedShard[K, V]) Set(key K, value V) {
+	s.check(key, true)
+	s.shard.store(key, value, OpSet)
+	s.shard.clearTTL(key)
+}
+
+// SetIfAbsent sets the given value under given key if no value was associated with it.
+func (s *LockedShard[K, V]) SetIfAbsent(key K, value V) bool {
+	s.check(key, true)
+	s.shard.purgeExpired(key)
+	if _, ok := s.shard.items[key]; ok {
+		return false
+	}
+	s.shard.store(key, value, OpSet)
+	return true
+}
+
+// Upsert updates the existing element or inserts a new one using cb.
+func (s *LockedShard[K, V]) Upsert(key K, value V, cb UpsertFunc[V]) V {
+	s.check(key, true)
+	s.shard.purgeExpired(key)
+	v, ok := s.shard.items[key]
+	res := cb(ok, v, value)
+	s.shard.store(key, res, OpUpsert)
+	return res
+}
+
+// Remove removes the element under given key.
+func (s *LockedShard[K, V]) Remove(key K) {
+	s.check(key, true)
+	s.shard.drop(key, OpRemove)
+}
+
+// Pop removes the element under given key and returns it.
+func (s *LockedShard[K, V]) Pop(key K) (V, bool) {
+	s.check(key, true)
+	s.shard.purgeExpired(key)
+	return s.shard.drop(key, OpPop)
+}
+
+// Count returns the number of elements in the shard.
+func (s *LockedShard[K, V]) Count() int {
+	if s.shard == nil {
+		panic("cmap: LockedShard used after its callback returned")
+	}
+	return s.shard.count()
+}
+
+// IterCb calls fn for every element of the shard. fn must not modify the shard.
+func (s *LockedShard[K, V]) IterCb(fn IterFunc[K, V]) {
+	if s.shard == nil {
+		panic("cmap: LockedShard used after its callback returned")
+	}
+	now := s.shard.now()
+	for key, value := range s.shard.items {
+		if !s.shard.expiredAt(key, now) {
+			fn(key, value)
+		}
+	}
+}
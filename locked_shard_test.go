@@ -0,0 +1,163 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestWithShard(t *testing.T) {
+	m := NewMap[string, int]()
+	m.Set("a", 1)
+	m.WithShard("a", func(s *LockedShard[string, int]) {
+		v, ok := s.Get("a")
+		if !ok || v != 1 {
+			t.Error("LockedShard.Get should see the map content.")
+		}
+		s.Set("a", v+1)
+		if s.SetIfAbsent("a", 10) {
+			t.Error("SetIfAbsent should not overwrite.")
+		}
+		s.Upsert("a", 1, func(exist bool, valueInMap, newValue int) int { return valueInMap + newValue })
+	})
+	if v, _ := m.Get("a"); v != 3 {
+		t.Error("Expecting 3, got", v)
+	}
+
+	m.WithShard("a", func(s *LockedShard[string, int]) {
+		if v, ok := s.Pop("a"); !ok || v != 3 || s.Has("a") {
+			t.Error("Pop should remove the element.")
+		}
+	})
+	if m.Has("a") {
+		t.Error("a should be removed.")
+	}
+}
+
+func TestWithShardForeignKey(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(2))
+	// Find two keys in different shards.
+	a, b := "0", ""
+	for i := 1; b == ""; i++ {
+		if m.GetShard(strconv.Itoa(i)) != m.GetShard(a) {
+			b = strconv.Itoa(i)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("keys of other shards should panic.")
+		}
+		m.Set(a, 1) // the shard must have been unlocked
+	}()
+	m.WithShard(a, func(s *LockedShard[string, int]) {
+		s.Set(b, 1)
+	})
+}
+
+func TestLockedShardAfterCallback(t *testing.T) {
+	m := NewMap[string, int]()
+	var leaked *LockedShard[string, int]
+	m.WithShard("a", func(s *LockedShard[string, int]) { leaked = s })
+	defer func() {
+		if recover() == nil {
+			t.Error("using a handle after its callback should panic.")
+		}
+	}()
+	leaked.Set("a", 1)
+}
+
+func TestReadShard(t *testing.T) {
+	m := NewMap[string, int]()
+	m.Set("a", 1)
+	m.ReadShard("a", func(s *LockedShard[string, int]) {
+		if v, ok := s.Get("a"); !ok || v != 1 {
+			t.Error("ReadShard should see the map content.")
+		}
+		count := 0
+		s.IterCb(func(key string, v int) { count++ })
+		if count != s.Count() {
+			t.Error("IterCb and Count disagree.")
+		}
+		defer func() {
+			if recover() == nil {
+				t.Error("writes through a read locked shard should panic.")
+			}
+		}()
+		s.Set("a", 2)
+	})
+}
+
+func TestNestedCMapInnerLocks(t *testing.T) {
+	m := NewNestedCMap()
+	m.SetInnerKeyVal("user", "roles", "admin")
+	m.SetInnerKeyVal("user", "roles", "dev")
+	if values, ok := m.GetInnerValues("user", "roles"); !ok || len(values) != 2 {
+		t.Error("Expecting two roles, got", values)
+	}
+	m.DeleteInnerKeyVal("user", "roles", "dev")
+	if values, ok := m.GetInnerValues("user", "roles"); !ok || len(values) != 1 {
+		t.Error("Expecting one role, got", values)
+	}
+
+	g := NewNestedGSet()
+	g.SetMultiStrValues("k", []string{"a", "b"})
+	if values, ok := g.PopStrValues("k"); !ok || len(values) != 2 || g.Has("k") {
+		t.Error("PopStrValues should return and remove the set.")
+	}
+}
+
+func TestNestedHandles(t *testing.T) {
+	g := NewNestedGSet()
+	g.WithShard("k", func(s *LockedGSet) {
+		s.SetMultiValues("k", []interface{}{"a", "b"})
+		if !s.SetValue("k", "c") || s.SetValue("k", "c") {
+			t.Error("SetValue should report new values only.")
+		}
+		s.DeleteMultipleValues("k", []interface{}{"a"})
+		if values, ok := s.GetStrValues("k"); !ok || len(values) != 2 {
+			t.Error("Expecting two values, got", values)
+		}
+	})
+	g.ReadShard("k", func(s *LockedGSet) {
+		if !s.HasValue("k", "b") || s.HasValue("k", "a") {
+			t.Error("ReadShard should see the values set through WithShard.")
+		}
+	})
+
+	c := NewNestedCMap()
+	c.WithShard("user", func(s *LockedNestedCMap) {
+		s.SetInnerKeyVal("user", "roles", "admin")
+		s.SetInnerKeyVal("user", "roles", "dev")
+		s.DeleteInnerKeyVal("user", "roles", "dev")
+		if values, ok := s.GetInnerValues("user", "roles"); !ok || len(values) != 1 || values[0] != "admin" {
+			t.Error("Expecting the admin role, got", values)
+		}
+	})
+
+	u := NewUint64Map()
+	u.WithShard("n", func(s *LockedUint64Map) {
+		s.InsertOrIncrementKey("n")
+		if s.InsertOrIncrementKey("n") != 2 || s.DecrementOrDeleteKey("n") {
+			t.Error("the counter should go to 2 and back to 1.")
+		}
+	})
+}
+
+func TestSelfLockingNoLock(t *testing.T) {
+	// These took the shard lock before the handles existed and still do.
+	g := NewNestedGSet()
+	g.SetMultiValuesNoLock("k", []interface{}{"a", "b", "c"})
+	g.DeleteMultipleValuesNoLock("k", []interface{}{"c"})
+	if values, ok := g.GetValuesNoLock("k"); !ok || len(values) != 2 {
+		t.Error("Expecting two values, got", values)
+	}
+	if values, ok := g.GetStrValuesNoLock("k"); !ok || len(values) != 2 {
+		t.Error("Expecting two values, got", values)
+	}
+
+	c := NewNestedCMap()
+	c.SetInnerKeyVal("user", "roles", "admin")
+	c.DeleteInnerKeyValNoLock("user", "roles", "admin")
+	if values, _ := c.GetInnerValues("user", "roles"); len(values) != 0 {
+		t.Error("DeleteInnerKeyValNoLock should remove the value.", values)
+	}
+}
@@ -0,0 +1,212 @@
+package cmap
+
+import "reflect"
+
+// MapDiff lists the keys that differ between two maps, see Diff.
+type MapDiff[K comparable] struct {
+	Added   []K // in the other map only
+	Removed []K // in this map only
+	Changed []K // in both, with different values
+}
+
+// sameSharding reports whether other places every key in the shard of the
+// same position as m when both have the same shard count.
+func (m *Map[K, V]) sameSharding(other *Map[K, V]) bool {
+	a, b := m.opts, other.opts
+	if a.sharding != nil || b.sharding != nil {
+		// Functions can not be compared.
+		return false
+	}
+	if a.prefixSep != b.prefixSep || a.prefixSegments != b.prefixSegments {
+		return false
+	}
+	return a.hasher == nil && b.hasher == nil || a.hasher != nil && b.hasher != nil && sameHasher(a.hasher, b.hasher)
+}
+
+// sameHasher compares two hashers, which may not be comparable.
+func sameHasher(a, b Hasher) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// eachShardPair calls fn with the shards of m and other at the same
+// positions, locking each pair in creation order, the shard of m for
+// writing if write. It returns false, without calling fn, unless both maps
+// place keys alike and have the same shard count with no Resize running.
+func (m *Map[K, V]) eachShardPair(other *Map[K, V], write bool, fn func(mine, theirs *Shard[K, V])) bool {
+	mine := m.pinShards()
+	defer m.unpinShards()
+	theirs := other.pinShards()
+	defer other.unpinShards()
+	if len(mine) != len(theirs) || !m.settled(mine) || !other.settled(theirs) {
+		return false
+	}
+	for i, a := range mine {
+		b := theirs[i]
+		lockA := func() {
+			if write {
+				a.Lock()
+			} else {
+				a.RLock()
+			}
+		}
+		if a.seq < b.seq {
+			lockA()
+			b.RLock()
+		} else {
+			b.RLock()
+			lockA()
+		}
+		fn(a, b)
+		b.RUnlock()
+		if write {
+			m.unlock(a)
+		} else {
+			a.RUnlock()
+		}
+	}
+	return true
+}
+
+// Diff compares m to other and returns the keys other added, removed and
+// changed. Values are compared with equal, or with the equality of m (see
+// WithEquality) if equal is nil. When both maps were created with the same
+// shard count and sharding options, the shards are compared pair by pair,
+// each pair read locked together, otherwise both maps are copied first.
+// Neither way is a snapshot of the whole maps.
+func (m *Map[K, V]) Diff(other *Map[K, V], equal func(a, b V) bool) MapDiff[K] {
+	if equal == nil {
+		equal = m.equal
+	}
+	var d MapDiff[K]
+	if m == other {
+		return d
+	}
+	if m.sameSharding(other) && m.eachShardPair(other, false, func(mine, theirs *Shard[K, V]) {
+		diffItems(&d, mine.liveItems(), theirs.liveItems(), equal)
+	}) {
+		return d
+	}
+	diffItems(&d, m.Items(), other.Items(), equal)
+	return d
+}
+
+// liveItems returns the items of the shard, without the expired ones.
+// Lock must be held.
+func (s *Shard[K, V]) liveItems() map[K]V {
+	now := s.now()
+	if now == 0 {
+		return s.items
+	}
+	items := make(map[K]V, len(s.items))
+	for key, v := range s.items {
+		if !s.expiredAt(key, now) {
+			items[key] = v
+		}
+	}
+	return items
+}
+
+func diffItems[K comparable, V any](d *MapDiff[K], mine, theirs map[K]V, equal func(a, b V) bool) {
+	for key, v := range mine {
+		if w, ok := theirs[key]; !ok {
+			d.Removed = append(d.Removed, key)
+		} else if !equal(v, w) {
+			d.Changed = append(d.Changed, key)
+		}
+	}
+	for key := range theirs {
+		if _, ok := mine[key]; !ok {
+			d.Added = append(d.Added, key)
+		}
+	}
+}
+
+// Merge copies the entries of other into m. For keys in both maps, the
+// value becomes conflict(key, mine, theirs), or theirs if conflict is nil,
+// and the entry keeps its TTL in m. New keys take their TTL from other.
+// conflict runs while shards of both maps are locked and must not use them.
+// Like Diff, maps with the same shard count and sharding options are merged
+// shard against shard.
+func (m *Map[K, V]) Merge(other *Map[K, V], conflict func(key K, mine, theirs V) V) {
+	if m == other {
+		return
+	}
+	m.merge(other, conflict, m.sameSharding(other))
+}
+
+func (m *Map[K, V]) merge(other *Map[K, V], conflict func(key K, mine, theirs V) V, sameSharding bool) {
+	if sameSharding && m.eachShardPair(other, true, func(mine, theirs *Shard[K, V]) {
+		now := theirs.now()
+		for key, v := range theirs.items {
+			if !theirs.expiredAt(key, now) {
+				mine.mergeEntry(key, v, theirs.expires[key], conflict)
+			}
+		}
+	}) {
+		return
+	}
+	for _, e := range other.entries() {
+		shard := m.lockKey(e.key)
+		shard.mergeEntry(e.key, e.val, e.deadline, conflict)
+		m.unlock(shard)
+	}
+}
+
+// ttlEntry is an entry with its TTL deadline, 0 if it has none.
+type ttlEntry[K comparable, V any] struct {
+	key      K
+	val      V
+	deadline int64
+}
+
+// entries returns the live entries of the map with their TTL deadlines.
+func (m *Map[K, V]) entries() []ttlEntry[K, V] {
+	var entries []ttlEntry[K, V]
+	shards := m.pinShards()
+	defer m.unpinShards()
+	for _, shard := range shards {
+		entries = shard.appendLiveTTL(entries)
+	}
+	return entries
+}
+
+// appendLiveTTL appends the live entries of the shard with their TTL
+// deadlines to buf, under the read lock.
+func (s *Shard[K, V]) appendLiveTTL(buf []ttlEntry[K, V]) []ttlEntry[K, V] {
+	s.RLock()
+	now := s.now()
+	for key, v := range s.items {
+		if !s.expiredAt(key, now) {
+			buf = append(buf, ttlEntry[K, V]{key, v, s.expires[key]})
+		}
+	}
+	s.RUnlock()
+	return buf
+}
+
+// mergeEntry merges an entry of another map into the shard, see Merge.
+// Write lock must be held.
+func (s *Shard[K, V]) mergeEntry(key K, v V, deadline int64, conflict func(key K, mine, theirs V) V) {
+	s.purgeExpired(key)
+	mine, exists := s.items[key]
+	if exists && conflict != nil {
+		v = conflict(key, mine, v)
+	}
+	s.store(key, v, OpSet)
+	if !exists && deadline != 0 {
+		s.setDeadline(key, deadline)
+	}
+}
+
+// Clone returns a copy of the map with the same options and shard count.
+// Entries keep their TTLs. Indexes, watchers and the write-ahead log of the
+// map are not carried over, while a WithJanitor option starts a janitor of
+// the copy's own, stopped by Close.
+func (m *Map[K, V]) Clone() *Map[K, V] {
+	o := m.opts
+	o.shardCount = m.ShardCount()
+	c := newFromOptions[K, V](o)
+	c.merge(m, nil, true)
+	return c
+}
@@ -0,0 +1,98 @@
+package cmap
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func sortedDiff(d MapDiff[string]) MapDiff[string] {
+	slices.Sort(d.Added)
+	slices.Sort(d.Removed)
+	slices.Sort(d.Changed)
+	return d
+}
+
+func TestDiff(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithShardCount(7)}} {
+		a := NewMap[string, int]()
+		b := NewWithOptions[string, int](opts...)
+		a.Set("same", 1)
+		b.Set("same", 1)
+		a.Set("changed", 1)
+		b.Set("changed", 2)
+		a.Set("removed", 1)
+		b.Set("added", 1)
+
+		d := sortedDiff(a.Diff(b, nil))
+		if !slices.Equal(d.Added, []string{"added"}) || !slices.Equal(d.Removed, []string{"removed"}) || !slices.Equal(d.Changed, []string{"changed"}) {
+			t.Errorf("unexpected diff %+v", d)
+		}
+		d = a.Diff(b, func(x, y int) bool { return true })
+		if len(d.Changed) != 0 {
+			t.Error("Diff should compare values with equal.", d.Changed)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	now := fakeClock(t)
+	for _, opts := range [][]Option{nil, {WithShardCount(7)}} {
+		a := NewMap[string, int]()
+		b := NewWithOptions[string, int](opts...)
+		a.Set("both", 1)
+		b.Set("both", 2)
+		a.Set("mine", 3)
+		b.SetWithTTL("theirs", 4, time.Second)
+
+		a.Merge(b, func(key string, mine, theirs int) int { return mine + theirs })
+		if v, _ := a.Get("both"); v != 3 {
+			t.Error("conflicts should be resolved with conflict.", v)
+		}
+		if v, _ := a.Get("theirs"); v != 4 || a.Count() != 3 {
+			t.Error("Merge should copy the other entries.", a.Items())
+		}
+		a.Merge(b, nil)
+		if v, _ := a.Get("both"); v != 2 {
+			t.Error("without conflict the other value wins.", v)
+		}
+		*now += int64(time.Second)
+		if a.Has("theirs") {
+			t.Error("merged entries should keep their TTL.")
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	now := fakeClock(t)
+	m := NewWithOptions[string, int](WithShardCount(4), WithCapacity(10))
+	m.Set("a", 1)
+	m.SetWithTTL("b", 2, time.Second)
+	c := m.Clone()
+	if c.ShardCount() != 4 || c.Count() != 2 || len(m.Diff(c, nil).Changed) != 0 {
+		t.Error("Clone should copy every entry.", c.Items())
+	}
+	c.Set("a", 5)
+	if v, _ := m.Get("a"); v != 1 {
+		t.Error("the clone should be independent.", v)
+	}
+	*now += int64(time.Second)
+	if c.Has("b") {
+		t.Error("Clone should keep TTLs.")
+	}
+	for i := 0; i < 20; i++ {
+		c.Set(string(rune('c'+i)), i)
+	}
+	if c.Count() != 10 {
+		t.Error("the clone should keep the capacity.", c.Count())
+	}
+}
+
+func TestMergeSelf(t *testing.T) {
+	m := NewMap[string, int]()
+	m.Set("a", 1)
+	m.Merge(m, nil) // must not deadlock
+	if d := m.Diff(m, nil); len(d.Added)+len(d.Removed)+len(d.Changed) != 0 {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,343 @@
+package cmap
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// WithMetrics instruments the shards with operation counters and lock
+// timings, reported by Stats. Without it, shards only pay a nil check.
+func WithMetrics() Option {
+	return func(o *options) {
+		o.metrics = true
+	}
+}
+
+// shardStats are the counters of an instrumented shard.
+type shardStats struct {
+	gets      atomic.Uint64
+	ops       [OpEvict + 1]atomic.Uint64 // writes by EventOp
+	locks     atomic.Uint64
+	wait      atomic.Int64 // nanoseconds
+	hold      atomic.Int64 // nanoseconds
+	lockedAt  int64        // start of the current write hold, guarded by the lock
+	readers   atomic.Int64
+	readSince atomic.Int64 // start of the current read hold
+}
+
+// statsEpoch is the origin of the monotonic clock used for lock timings.
+var statsEpoch = time.Now()
+
+func monotime() int64 {
+	return int64(time.Since(statsEpoch))
+}
+
+// configureMetrics instruments new shards if the map was created WithMetrics.
+func (m *Map[K, V]) configureMetrics(shards []*Shard[K, V]) {
+	if !m.opts.metrics {
+		return
+	}
+	for _, shard := range shards {
+		shard.stats = new(shardStats)
+	}
+}
+
+// Lock write locks the shard, timing the wait and the hold when metrics are on.
+func (s *Shard[K, V]) Lock() {
+	st := s.stats
+	if st == nil {
+		s.RWMutex.Lock()
+		return
+	}
+	start := monotime()
+	s.RWMutex.Lock()
+	now := monotime()
+	st.wait.Add(now - start)
+	st.locks.Add(1)
+	st.lockedAt = now
+}
+
+// tryLock is Lock without waiting. It reports whether the lock was taken.
+func (s *Shard[K, V]) tryLock() bool {
+	if !s.RWMutex.TryLock() {
+		return false
+	}
+	if st := s.stats; st != nil {
+		st.locks.Add(1)
+		st.lockedAt = monotime()
+	}
+	return true
+}
+
+// Unlock releases the write lock of the shard, then delivers what the writes
+// made under it queued, as the methods of the map do: watch events, OnEvict
+// and OnExpire notifications, evictions for the budget and the panic of a
+// write the log failed to record.
+func (s *Shard[K, V]) Unlock() {
+	s.owner.unlock(s)
+}
+
+// release releases the write lock of the shard, first publishing the changes
+// made under it for lock free reads, see WithReadMostly.
+func (s *Shard[K, V]) release() {
+	if s.dirty {
+		s.dirty = false
+		if s.snap.Load() != nil {
+			s.publishSnapshot()
+		}
+	}
+	if st := s.stats; st != nil {
+		st.hold.Add(monotime() - st.lockedAt)
+	}
+	s.RWMutex.Unlock()
+}
+
+// RLock read locks the shard, timing the wait when metrics are on. The hold
+// time of read locks is the time at least one reader held the lock.
+func (s *Shard[K, V]) RLock() {
+	st := s.stats
+	if st == nil {
+		s.RWMutex.RLock()
+		return
+	}
+	start := monotime()
+	s.RWMutex.RLock()
+	now := monotime()
+	st.wait.Add(now - start)
+	st.locks.Add(1)
+	if st.readers.Add(1) == 1 {
+		st.readSince.Store(now)
+	}
+}
+
+// RUnlock releases a read lock of the shard.
+func (s *Shard[K, V]) RUnlock() {
+	if st := s.stats; st != nil && st.readers.Add(-1) == 0 {
+		// A reader arriving meanwhile may have moved readSince, which only
+		// makes this hold shorter.
+		if d := monotime() - st.readSince.Load(); d > 0 {
+			st.hold.Add(d)
+		}
+	}
+	s.RWMutex.RUnlock()
+}
+
+func (s *Shard[K, V]) countGet() {
+	if st := s.stats; st != nil {
+		st.gets.Add(1)
+	}
+}
+
+func (s *Shard[K, V]) countOp(op EventOp) {
+	if st := s.stats; st != nil {
+		st.ops[op].Add(1)
+	}
+}
+
+// ShardStats are the counters of one shard, or their sum.
+type ShardStats struct {
+	Items int // live entries
+
+	Gets    uint64 // Get, Has and GetBytes
+	Sets    uint64 // OpSet writes
+	Upserts uint64
+	Removes uint64
+	Pops    uint64
+	Expired uint64
+	Evicted uint64
+
+	Locks    uint64        // lock acquisitions, read or write
+	LockWait time.Duration // time spent waiting for the lock
+	LockHold time.Duration // time the lock was held
+}
+
+// Ops returns the number of operations counted in s.
+func (s ShardStats) Ops() uint64 {
+	return s.Gets + s.Sets + s.Upserts + s.Removes + s.Pops + s.Expired + s.Evicted
+}
+
+func (s *ShardStats) add(o ShardStats) {
+	s.Items += o.Items
+	s.Gets += o.Gets
+	s.Sets += o.Sets
+	s.Upserts += o.Upserts
+	s.Removes += o.Removes
+	s.Pops += o.Pops
+	s.Expired += o.Expired
+	s.Evicted += o.Evicted
+	s.Locks += o.Locks
+	s.LockWait += o.LockWait
+	s.LockHold += o.LockHold
+}
+
+// Stats are the metrics of a map, see WithMetrics.
+type Stats struct {
+	Enabled bool // whether the map was created WithMetrics
+	Shards  []ShardStats
+	Total   ShardStats
+	// Skew is the operation count of the busiest shard divided by the mean,
+	// 1 for an even load and ShardCount when a single shard gets everything.
+	Skew float64
+}
+
+// Stats returns the item counts of the shards and, with WithMetrics, their
+// operation counters and lock timings. Counters are read one by one while
+// the map is in use, so they are not a consistent snapshot.
+func (m *Map[K, V]) Stats() Stats {
+	shards := m.pinShards()
+	defer m.unpinShards()
+	st := Stats{Shards: make([]ShardStats, len(shards))}
+	var busiest uint64
+	for i, shard := range shards {
+		s := &st.Shards[i]
+		// Bypass the instrumentation, reading the stats is not map traffic.
+		shard.RWMutex.RLock()
+		s.Items = shard.count()
+		shard.RWMutex.RUnlock()
+		if c := shard.stats; c != nil {
+			st.Enabled = true
+			s.Gets = c.gets.Load()
+			s.Sets = c.ops[OpSet].Load()
+			s.Upserts = c.ops[OpUpsert].Load()
+			s.Removes = c.ops[OpRemove].Load()
+			s.Pops = c.ops[OpPop].Load()
+			s.Expired = c.ops[OpExpire].Load()
+			s.Evicted = c.ops[OpEvict].Load()
+			s.Locks = c.locks.Load()
+			s.LockWait = time.Duration(c.wait.Load())
+			s.LockHold = time.Duration(c.hold.Load())
+		}
+		st.Total.add(*s)
+		busiest = max(busiest, s.Ops())
+	}
+	if total := st.Total.Ops(); total > 0 {
+		st.Skew = float64(busiest) * float64(len(shards)) / float64(total)
+	}
+	return st
+}
+
+// PublishExpvar exports the Stats of the map as the expvar variable name.
+// Like expvar.Publish, it panics if the name is already in use.
+func (m *Map[K, V]) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return m.Stats() }))
+}
+
+// WritePrometheus writes the Stats of the map to w in the Prometheus text
+// exposition format, labelled with map="name". To export several maps in
+// one scrape, use WritePrometheusMaps.
+func (m *Map[K, V]) WritePrometheus(w io.Writer, name string) error {
+	return WritePrometheusMaps(w, map[string]StatsSource{name: m})
+}
+
+// StatsSource is a map whose Stats can be exported, whatever its key and
+// value types.
+type StatsSource interface {
+	Stats() Stats
+}
+
+// WritePrometheusMaps writes the Stats of maps to w in the Prometheus text
+// exposition format. Each metric family is written once, with the samples
+// of every map labelled with map="name" under it, in name order.
+func WritePrometheusMaps(w io.Writer, maps map[string]StatsSource) error {
+	names := make([]string, 0, len(maps))
+	for name := range maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var all, metered []namedStats
+	for _, name := range names {
+		st := namedStats{name, maps[name].Stats()}
+		all = append(all, st)
+		if st.Enabled {
+			metered = append(metered, st)
+		}
+	}
+
+	p := promWriter{w: w}
+	p.shardFamily("cmap_items", "gauge", "Live entries per shard.", all, func(s ShardStats) float64 {
+		return float64(s.Items)
+	})
+	if len(metered) == 0 {
+		return p.err
+	}
+	p.family("cmap_operations_total", "counter", "Operations per shard and type.")
+	for _, st := range metered {
+		for i, s := range st.Shards {
+			for _, op := range []struct {
+				name string
+				n    uint64
+			}{
+				{"get", s.Gets}, {"set", s.Sets}, {"upsert", s.Upserts}, {"remove", s.Removes},
+				{"pop", s.Pops}, {"expire", s.Expired}, {"evict", s.Evicted},
+			} {
+				p.sample("cmap_operations_total", st.name, i, op.name, float64(op.n))
+			}
+		}
+	}
+	p.shardFamily("cmap_lock_acquisitions_total", "counter", "Shard lock acquisitions.", metered, func(s ShardStats) float64 {
+		return float64(s.Locks)
+	})
+	p.shardFamily("cmap_lock_wait_seconds_total", "counter", "Time spent waiting for shard locks.", metered, func(s ShardStats) float64 {
+		return s.LockWait.Seconds()
+	})
+	p.shardFamily("cmap_lock_hold_seconds_total", "counter", "Time shard locks were held.", metered, func(s ShardStats) float64 {
+		return s.LockHold.Seconds()
+	})
+	p.family("cmap_shard_skew", "gauge", "Operations of the busiest shard divided by the mean.")
+	for _, st := range metered {
+		p.sample("cmap_shard_skew", st.name, -1, "", st.Skew)
+	}
+	return p.err
+}
+
+// namedStats is the Stats of a map exported under name.
+type namedStats struct {
+	name string
+	Stats
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promWriter writes metric samples, keeping the first error.
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *promWriter) family(metric, kind, help string) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", metric, help, metric, kind)
+	}
+}
+
+// shardFamily writes a family with one sample per shard of each map.
+func (p *promWriter) shardFamily(metric, kind, help string, stats []namedStats, value func(s ShardStats) float64) {
+	p.family(metric, kind, help)
+	for _, st := range stats {
+		for i, s := range st.Shards {
+			p.sample(metric, st.name, i, "", value(s))
+		}
+	}
+}
+
+// sample writes one sample of map name, without the shard label for a
+// negative shard and without the op label when op is empty.
+func (p *promWriter) sample(metric, name string, shard int, op string, v float64) {
+	if p.err != nil {
+		return
+	}
+	labels := `map="` + promEscaper.Replace(name) + `"`
+	if shard >= 0 {
+		labels += `,shard="` + strconv.Itoa(shard) + `"`
+	}
+	if op != "" {
+		labels += `,op="` + op + `"`
+	}
+	_, p.err = fmt.Fprintf(p.w, "%s{%s} %g\n", metric, labels, v)
+}
@@ -0,0 +1,142 @@
+package cmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(4), WithMetrics())
+	m.Set("a", 1)
+	m.Upsert("a", 1, func(exist bool, old, v int) int { return old + v })
+	m.Get("a")
+	m.Has("b")
+	m.Set("b", 2)
+	m.Pop("b")
+	m.Remove("a")
+	m.Set("c", 3)
+
+	st := m.Stats()
+	if !st.Enabled || len(st.Shards) != 4 {
+		t.Fatal("Stats should report every shard.", st)
+	}
+	tot := st.Total
+	if tot.Items != 1 || tot.Gets != 2 || tot.Sets != 3 || tot.Upserts != 1 || tot.Pops != 1 || tot.Removes != 1 {
+		t.Errorf("unexpected totals %+v", tot)
+	}
+	if tot.Locks == 0 || tot.LockHold <= 0 {
+		t.Errorf("lock timings should be recorded %+v", tot)
+	}
+	if st.Skew < 1 || st.Skew > 4 {
+		t.Error("skew should be between 1 and the shard count.", st.Skew)
+	}
+}
+
+func TestStatsSkew(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(4), WithMetrics())
+	for i := 0; i < 10; i++ {
+		m.Set("hot", i)
+	}
+	if st := m.Stats(); st.Skew != 4 {
+		t.Error("a single hot shard should have a skew of the shard count.", st.Skew)
+	}
+}
+
+func TestStatsDisabled(t *testing.T) {
+	m := NewMap[string, int]()
+	m.Set("a", 1)
+	st := m.Stats()
+	if st.Enabled || st.Total.Items != 1 || st.Total.Sets != 0 {
+		t.Errorf("without metrics only items should be reported %+v", st.Total)
+	}
+}
+
+func TestStatsLockWait(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(1), WithMetrics())
+	done := make(chan struct{})
+	m.WithShard("a", func(s *LockedShard[string, int]) {
+		go func() {
+			m.Set("b", 1)
+			close(done)
+		}()
+		time.Sleep(10 * time.Millisecond)
+	})
+	<-done
+	if wait := m.Stats().Total.LockWait; wait < 5*time.Millisecond {
+		t.Error("waiting for the lock should be recorded.", wait)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	m := NewWithOptions[string, int](WithMetrics())
+	m.Set("a", 1)
+	// expvar names can not be reused, keep them unique across -count runs.
+	name := "cmap_test_stats_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	m.PublishExpvar(name)
+	var st Stats
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Total.Sets != 1 {
+		t.Error("expvar should export the stats.", st.Total)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(2), WithMetrics())
+	m.Set("a", 1)
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf, "sessions"); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE cmap_operations_total counter\n",
+		`cmap_items{map="sessions",shard="0"} `,
+		`cmap_operations_total{map="sessions",shard="1",op="set"} `,
+		`cmap_lock_wait_seconds_total{map="sessions",shard="0"} `,
+		`cmap_shard_skew{map="sessions"} 2` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestWritePrometheusMaps(t *testing.T) {
+	sessions := NewWithOptions[string, int](WithShardCount(2), WithMetrics())
+	sessions.Set("a", 1)
+	users := NewWithOptions[int, string](WithShardCount(2))
+	users.Set(1, "ann")
+	var buf bytes.Buffer
+	if err := WritePrometheusMaps(&buf, map[string]StatsSource{"sessions": sessions, "users": users}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, family := range []string{"cmap_items", "cmap_operations_total", "cmap_shard_skew"} {
+		if n := strings.Count(out, "# TYPE "+family+" "); n != 1 {
+			t.Errorf("%s should be declared once, got %d:\n%s", family, n, out)
+		}
+	}
+	for _, want := range []string{
+		`cmap_items{map="sessions",shard="1"} `,
+		`cmap_items{map="users",shard="0"} `,
+		`cmap_operations_total{map="sessions",shard="0",op="set"} `,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `cmap_operations_total{map="users"`) {
+		t.Error("maps without metrics should only export their items.")
+	}
+	// The samples of a family follow its header.
+	if strings.Index(out, `cmap_items{map="users"`) > strings.Index(out, "# TYPE cmap_operations_total") {
+		t.Error("samples should be grouped under their family.")
+	}
+}
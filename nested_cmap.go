@@ -2,13 +2,13 @@ package cmap
 
 // NestedCMap Cmap with NestedGSet as values CMap<NestedGSet>
 type NestedCMap struct {
-	_cmap ConcurrentMap
+	_cmap *Map[string, interface{}]
 }
 
 // NewNestedCMap return NestedCMap
 func NewNestedCMap() *NestedCMap {
 	m := new(NestedCMap)
-	m._cmap = New()
+	m._cmap = NewMap[string, interface{}]()
 	return m
 }
 
@@ -58,11 +58,11 @@ func (m *NestedCMap) SetInnerKey(key, innerKey string) {
 	// get innerCmap
 	if nestedGSetVal, exist := outerShard.items[key]; exist { // NestedGSet already exist for <key>
 		if nestedGSet, okNesetedGSet := nestedGSetVal.(*NestedGSet); okNesetedGSet { // convert
-			nestedGSet._cmap.SetNoLock(innerKey, struct{}{}) // set innerkey for nested gset
+			nestedGSet._cmap.Set(innerKey, struct{}{}) // set innerkey for nested gset
 		}
 	} else { // nestedGset not exist for key
 		nestedGSet := NewNestedGSet()                    // create new NestedGSet
-		nestedGSet._cmap.SetNoLock(innerKey, struct{}{}) // set innerKey in nestedGset
+		nestedGSet._cmap.Set(innerKey, struct{}{}) // set innerKey in nestedGset
 		outerShard.items[key] = nestedGSet               // set nestedGset in cmap for key
 	}
 }
@@ -77,28 +77,32 @@ func (m *NestedCMap) SetInnerKeyVal(key, innerKey, innerVal string) {
 	if nestedGSetVal, exist := outerShard.items[key]; exist { // cmap already exist for <key, innerKey>
 		// cmap already exist for <key, innerKey>
 		if nestedGSet, okNestedGSet := nestedGSetVal.(*NestedGSet); okNestedGSet { // convert
-			nestedGSet.SetValueNoLock(innerKey, innerVal)
+			nestedGSet.SetValue(innerKey, innerVal)
 		}
 	} else {
 		// key or innerkey not exist
 		nestedGSet := NewNestedGSet()                 // create new NestedGSet
-		nestedGSet.SetValueNoLock(innerKey, innerVal) // set inner key with struct
+		nestedGSet.SetValue(innerKey, innerVal) // set inner key with struct
 		outerShard.items[key] = nestedGSet            // set nestedGset in cmap for key
 	}
 }
 
 // SetInnerKeyValNoLock set inner key into inner NestedGSet (CMapOuter,cmap inner, gset (values))
 // CMap<key, NestedGSet<InnerKey[vals,...]>>
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use SetInnerKeyVal on the handle given by WithShard instead.
 func (m *NestedCMap) SetInnerKeyValNoLock(key, innerKey, innerVal string) {
 	outerShard := m._cmap.GetShard(key)
+	assertWriteLocked(&outerShard.RWMutex)
 	if nestedGSetVal, exist := outerShard.items[key]; exist { // nested gest exist in cmap for key
 		if nestedGSet, okGSet := nestedGSetVal.(*NestedGSet); okGSet { // convert
-			nestedGSet.SetValueNoLock(innerKey, innerVal) // set innerval in nestedGset for innerkey
+			nestedGSet.SetValue(innerKey, innerVal) // set innerval in nestedGset for innerkey
 		}
 	} else {
 		// key or innerkey not exist
 		nestedGSet := NewNestedGSet()                 // create new NestedGSet
-		nestedGSet.SetValueNoLock(innerKey, innerVal) // set innerVal to nested gset for innerKey
+		nestedGSet.SetValue(innerKey, innerVal) // set innerVal to nested gset for innerKey
 		outerShard.items[key] = nestedGSet            // set new nested gset for key
 	}
 }
@@ -113,13 +117,13 @@ func (m *NestedCMap) SetMultiInnerKeys(key string, innerKeys []string) {
 	if nestedGSetVal, exist := outerShard.items[key]; exist {
 		if nestedGSet, okNestedGSet := nestedGSetVal.(*NestedGSet); okNestedGSet {
 			for _, innerKey := range innerKeys {
-				nestedGSet._cmap.SetNoLock(innerKey, struct{}{})
+				nestedGSet._cmap.Set(innerKey, struct{}{})
 			}
 		}
 	} else { // key not exist in cmap
 		nestedGSet := NewNestedGSet()        // create new nestedGset
 		for _, innerKey := range innerKeys { // set innerkeys into new NestedGSet
-			nestedGSet._cmap.SetNoLock(innerKey, struct{}{})
+			nestedGSet._cmap.Set(innerKey, struct{}{})
 		}
 		outerShard.items[key] = nestedGSet // set new nested gset in cmap for key
 	}
@@ -134,7 +138,7 @@ func (m *NestedCMap) DeleteInnerKey(key, innerKey string) {
 	defer outerShard.Unlock()
 	if nestedGSetVal, exist := outerShard.items[key]; exist {
 		if nestedGSet, okGSet := nestedGSetVal.(*NestedGSet); okGSet {
-			nestedGSet._cmap.RemoveNoLock(innerKey)
+			nestedGSet._cmap.Remove(innerKey)
 			if nestedGSet.IsEmpty() {
 				delete(outerShard.items, key)
 			}
@@ -144,11 +148,15 @@ func (m *NestedCMap) DeleteInnerKey(key, innerKey string) {
 
 // DeleteInnerKeyNoLock delete one innerkey in inner NestedGSet for key
 //  Clear empty NestedGSet
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use DeleteInnerKey on the handle given by WithShard instead.
 func (m *NestedCMap) DeleteInnerKeyNoLock(key, innerKey string) {
 	outerShard := m._cmap.GetShard(key)
+	assertWriteLocked(&outerShard.RWMutex)
 	if nestedGSetVal, exist := outerShard.items[key]; exist {
 		if nestedGSet, okGSet := nestedGSetVal.(*NestedGSet); okGSet {
-			nestedGSet._cmap.RemoveNoLock(innerKey)
+			nestedGSet._cmap.Remove(innerKey)
 			if nestedGSet.IsEmpty() {
 				delete(outerShard.items, key)
 			}
@@ -165,7 +173,7 @@ func (m *NestedCMap) DeleteInnerKeyVal(key, innerKey, innerVal string) {
 	if nestedGSetVal, exist := outerShard.items[key]; exist {
 		// cmap already exist for <key, innerKey>
 		if nestedGSet, okGSet := nestedGSetVal.(*NestedGSet); okGSet {
-			nestedGSet.DeleteValueNoLock(innerKey, innerVal)
+			nestedGSet.DeleteValue(innerKey, innerVal)
 		}
 	}
 }
@@ -173,14 +181,23 @@ func (m *NestedCMap) DeleteInnerKeyVal(key, innerKey, innerVal string) {
 // DeleteInnerKeyValNoLock delete innerKey in inner NestedGSet for key
 // lock shard for outer key
 // CMap<key, NestedGSet<InnerKey[vals,...]>>
+//
+// Deprecated: despite its name it locks the shard, like DeleteInnerKeyVal.
+// Use DeleteInnerKeyVal, or DeleteInnerKeyVal on the handle given by
+// WithShard.
 func (m *NestedCMap) DeleteInnerKeyValNoLock(key, innerKey, innerVal string) {
 	outerShard := m._cmap.GetShard(key)
 	outerShard.Lock()
 	defer outerShard.Unlock()
+	deleteInnerKeyVal(outerShard, key, innerKey, innerVal)
+}
+
+// deleteInnerKeyVal delete innerKey in inner NestedGSet for key, shard must be write locked
+func deleteInnerKeyVal(outerShard *Shard[string, interface{}], key, innerKey, innerVal string) {
 	if nestedGSetVal, exist := outerShard.items[key]; exist {
 		// cmap already exist for <key, innerKey>
 		if nestedGSet, okGSet := nestedGSetVal.(*NestedGSet); okGSet {
-			nestedGSet.DeleteValueNoLock(innerKey, innerVal)
+			nestedGSet.DeleteValue(innerKey, innerVal)
 		}
 	}
 }
@@ -196,7 +213,7 @@ func (m *NestedCMap) DeleteInnerKeys(key string, innerKeys []string) {
 	if nestedGSetVal, exist := outerShard.items[key]; exist { // cmap exist for key
 		if nestedGSet, okNestedGSet := nestedGSetVal.(*NestedGSet); okNestedGSet {
 			for _, innerKey := range innerKeys {
-				nestedGSet._cmap.RemoveNoLock(innerKey)
+				nestedGSet._cmap.Remove(innerKey)
 			}
 			if nestedGSet.IsEmpty() {
 				delete(outerShard.items, key)
@@ -225,6 +242,7 @@ func (m *NestedCMap) getNestedGSet(key string) (*NestedGSet, bool) {
 // getNestedGSetNoLock returns inner NestedGSet for key
 func (m *NestedCMap) getNestedGSetNoLock(key string) (*NestedGSet, bool) {
 	shard := m._cmap.GetShard(key)
+	assertLocked(&shard.RWMutex)
 	if val, exist := shard.items[key]; exist {
 		if nestedGSet, okNestedGSet := val.(*NestedGSet); okNestedGSet {
 			if okNestedGSet {
@@ -248,6 +266,9 @@ func (m *NestedCMap) GetInnerKeys(key string) ([]string, bool) {
 }
 
 // GGetInnerKeysNoLock Get list of innerKeys in inner NestedGset
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use GetInnerKeys on the handle given by WithShard instead.
 func (m *NestedCMap) GGetInnerKeysNoLock(key string) ([]string, bool) {
 	if nestedGSet, exist := m.getNestedGSetNoLock(key); exist {
 		return nestedGSet._cmap.Keys(), true
@@ -265,7 +286,7 @@ func (m *NestedCMap) GetInnerValues(key, innerKey string) ([]string, bool) {
 	if nestedGsetVal, exist := outerShard.items[key]; exist {
 		// cmap already exist for <key, innerKey>
 		if nestedGSet, okCMap := nestedGsetVal.(*NestedGSet); okCMap {
-			if result, okInnerKey := nestedGSet.GetStrValuesNoLock(innerKey); okInnerKey {
+			if result, okInnerKey := nestedGSet.GetStrValues(innerKey); okInnerKey {
 				return result, true
 			}
 		}
@@ -275,16 +296,74 @@ func (m *NestedCMap) GetInnerValues(key, innerKey string) ([]string, bool) {
 
 // GetInnerValuesNoLock Get list of inner values in inner NestedGset
 // CMap<key, NestedGSet<InnerKey[vals,...]>> get [vals,...] ...
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use GetInnerValues on the handle given by WithShard instead.
 func (m *NestedCMap) GetInnerValuesNoLock(key, innerKey string) ([]string, bool) {
 	outerShard := m._cmap.GetShard(key)
+	assertLocked(&outerShard.RWMutex)
 	// get innerCmap
 	if nestedGsetVal, exist := outerShard.items[key]; exist {
 		// cmap already exist for <key, innerKey>
 		if nestedGSet, okCMap := nestedGsetVal.(*NestedGSet); okCMap {
-			if result, okInnerKey := nestedGSet.GetStrValuesNoLock(innerKey); okInnerKey {
+			if result, okInnerKey := nestedGSet.GetStrValues(innerKey); okInnerKey {
 				return result, true
 			}
 		}
 	}
 	return nil, false
 }
+
+// LockedNestedCMap is a handle on a locked shard of a NestedCMap, given to
+// the callbacks of WithShard and ReadShard. It is only valid during the
+// callback, and only for keys that belong to the locked shard.
+type LockedNestedCMap struct {
+	m *NestedCMap
+	s *LockedShard[string, interface{}]
+}
+
+// WithShard write locks the shard under given key and calls fn with a handle
+// on it, see Map.WithShard.
+func (m *NestedCMap) WithShard(key string, fn func(s *LockedNestedCMap)) {
+	m._cmap.WithShard(key, func(s *LockedShard[string, interface{}]) {
+		fn(&LockedNestedCMap{m: m, s: s})
+	})
+}
+
+// ReadShard read locks the shard under given key and calls fn with a handle
+// on it. Write operations of the handle panic.
+func (m *NestedCMap) ReadShard(key string, fn func(s *LockedNestedCMap)) {
+	m._cmap.ReadShard(key, func(s *LockedShard[string, interface{}]) {
+		fn(&LockedNestedCMap{m: m, s: s})
+	})
+}
+
+// SetInnerKeyVal set inner key into inner NestedGSet
+func (s *LockedNestedCMap) SetInnerKeyVal(key, innerKey, innerVal string) {
+	s.s.check(key, true)
+	s.m.SetInnerKeyValNoLock(key, innerKey, innerVal)
+}
+
+// DeleteInnerKey delete one innerkey in inner NestedGSet for key, clear empty NestedGSet
+func (s *LockedNestedCMap) DeleteInnerKey(key, innerKey string) {
+	s.s.check(key, true)
+	s.m.DeleteInnerKeyNoLock(key, innerKey)
+}
+
+// DeleteInnerKeyVal delete innerVal of innerKey in inner NestedGSet for key
+func (s *LockedNestedCMap) DeleteInnerKeyVal(key, innerKey, innerVal string) {
+	s.s.check(key, true)
+	deleteInnerKeyVal(s.s.shard, key, innerKey, innerVal)
+}
+
+// GetInnerKeys Get list of inner keys in inner NestedGSet
+func (s *LockedNestedCMap) GetInnerKeys(key string) ([]string, bool) {
+	s.s.check(key, false)
+	return s.m.GGetInnerKeysNoLock(key)
+}
+
+// GetInnerValues Get list of inner values in inner NestedGSet
+func (s *LockedNestedCMap) GetInnerValues(key, innerKey string) ([]string, bool) {
+	s.s.check(key, false)
+	return s.m.GetInnerValuesNoLock(key, innerKey)
+}
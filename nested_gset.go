@@ -4,13 +4,13 @@ import mapset "github.com/deckarep/golang-set"
 
 // NestedGSet CMap(<Gset>) ... key<set1>,key<set2>
 type NestedGSet struct {
-	_cmap ConcurrentMap
+	_cmap *Map[string, interface{}]
 }
 
 // NewNestedGSet CMap(<Gset>) key<set1>,key<set2>
 func NewNestedGSet() *NestedGSet {
 	m := new(NestedGSet)
-	m._cmap = New()
+	m._cmap = NewMap[string, interface{}]()
 	return m
 }
 
@@ -55,7 +55,7 @@ func (m *NestedGSet) Pop(key string) (interface{}, bool) {
 }
 
 // Set New Gset in Cmap for key
-func setNewGset(shard *ConcurrentMapShared, key string, newSet *mapset.Set) {
+func setNewGset(shard *Shard[string, interface{}], key string, newSet *mapset.Set) {
 	shard.items[key] = newSet
 }
 
@@ -86,8 +86,12 @@ func (m *NestedGSet) SetValue(key string, value interface{}) bool {
 // SetValueNoLock set inner key into inner set <key(Cmap), interkey(gset)>
 // false if gset already has key
 // CMap<key, GSet[val1,val2]>
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use SetValue on the handle given by WithShard instead.
 func (m *NestedGSet) SetValueNoLock(key string, value interface{}) bool {
 	outerShard := m._cmap.GetShard(key) //cmap
+	assertWriteLocked(&outerShard.RWMutex)
 	// whether item was added
 	if gsetVal, exist := outerShard.items[key]; exist { // get gset
 		// cmap already exist for <key, innerKey>
@@ -132,11 +136,18 @@ func (m *NestedGSet) SetMultiValues(key string, values []interface{}) {
 // SetMultiValuesNoLock set list of values into inner gset
 // lock shard for outer key
 // CMap<key, GSet[val1,val2]>
+//
+// Deprecated: despite its name it locks the shard, like SetMultiValues.
+// Use SetMultiValues, or SetMultiValues on the handle given by WithShard.
 func (m *NestedGSet) SetMultiValuesNoLock(key string, values []interface{}) {
 	outerShard := m._cmap.GetShard(key)
 	outerShard.Lock()
 	defer outerShard.Unlock()
+	setMultiValues(outerShard, key, values)
+}
 
+// setMultiValues set list of values into inner gset, shard must be write locked
+func setMultiValues(outerShard *Shard[string, interface{}], key string, values []interface{}) {
 	if gsetVal, exist := outerShard.items[key]; exist { // GSet already exist in cmap for key
 		// get existing inner cmap
 		if mySet, okConv := gsetVal.(*mapset.Set); okConv {
@@ -181,10 +192,13 @@ func (m *NestedGSet) SetMultiStrValues(key string, values []string) {
 }
 
 // SetMultiStrValuesNoLock set list of values into inner gset
-// lock shard for outer key
 // CMap<key, GSet[val1,val2]>
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use SetMultiStrValues on the handle given by WithShard instead.
 func (m *NestedGSet) SetMultiStrValuesNoLock(key string, values []string) {
 	outerShard := m._cmap.GetShard(key)
+	assertWriteLocked(&outerShard.RWMutex)
 	if gsetVal, exist := outerShard.items[key]; exist { // GSet already exist for key
 		// get existing inner cmap
 		if mySet, okConv := gsetVal.(*mapset.Set); okConv {
@@ -218,8 +232,12 @@ func (m *NestedGSet) HasValue(key string, value interface{}) bool {
 
 // HasValueNoLock check if inner gset has value
 // CMap<key, GSet[val1,val2]>
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use HasValue on the handle given by WithShard instead.
 func (m *NestedGSet) HasValueNoLock(key string, value interface{}) bool {
 	outerShard := m._cmap.GetShard(key)
+	assertLocked(&outerShard.RWMutex)
 	if gsetVal, exist := outerShard.items[key]; exist { // GSet already exist in cmap for key
 		if mySet, okConv := gsetVal.(*mapset.Set); okConv {
 			return (*mySet).Contains(value) // true if set contains value
@@ -249,9 +267,13 @@ func (m *NestedGSet) DeleteValue(key string, value interface{}) {
 // DeleteValueNoLock delete one value in inner GSet of outer key
 //  Clear empty gset
 // CMap<key, GSet[val1,val2]>
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use DeleteValue on the handle given by WithShard instead.
 func (m *NestedGSet) DeleteValueNoLock(key string, value interface{}) {
 
 	outerShard := m._cmap.GetShard(key)
+	assertWriteLocked(&outerShard.RWMutex)
 	if gsetVal, okVal := outerShard.items[key]; okVal {
 		if mySet, okSet := gsetVal.(*mapset.Set); okSet {
 			(*mySet).Remove(value) // remove value from gset for key
@@ -302,10 +324,19 @@ func (m *NestedGSet) DeleteMultipleStrValues(key string, values []string) {
 
 // DeleteMultipleValuesNoLock delete list of values in inner Gset for key in cmap
 // lock shard for outer key
+//
+// Deprecated: despite its name it locks the shard, like DeleteMultipleValues.
+// Use DeleteMultipleValues, or DeleteMultipleValues on the handle given by
+// WithShard.
 func (m *NestedGSet) DeleteMultipleValuesNoLock(key string, values []interface{}) {
 	outerShard := m._cmap.GetShard(key)
 	outerShard.Lock() // lock
 	defer outerShard.Unlock()
+	deleteMultipleValues(outerShard, key, values)
+}
+
+// deleteMultipleValues delete list of values in inner Gset for key, shard must be write locked
+func deleteMultipleValues(outerShard *Shard[string, interface{}], key string, values []interface{}) {
 	if gsetVal, exist := outerShard.items[key]; exist { // inner gset exist in cmap for key
 		if mySet, okSet := gsetVal.(*mapset.Set); okSet { // convert ok
 			for _, value := range values {
@@ -333,8 +364,12 @@ func (m *NestedGSet) GetGSet(key string) (*mapset.Set, bool) {
 }
 
 // GetGSetNoLock returns inner gset in cmap for key
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use GetGSet on the handle given by WithShard instead.
 func (m *NestedGSet) GetGSetNoLock(key string) (*mapset.Set, bool) {
 	outerShard := m._cmap.GetShard(key)
+	assertLocked(&outerShard.RWMutex)
 	// Get item from shard for given key.
 	if gsetVal, exist := outerShard.items[key]; exist { // inner gset exists in cmap for key
 		if mySet, okSet := gsetVal.(*mapset.Set); okSet { // convert
@@ -348,7 +383,7 @@ func (m *NestedGSet) GetGSetNoLock(key string) (*mapset.Set, bool) {
 func (m *NestedGSet) PopGSet(key string) (*mapset.Set, bool) {
 	outerShard := m._cmap.GetShard(key)
 	outerShard.Lock()
-	outerShard.Unlock()
+	defer outerShard.Unlock()
 	// Get item from shard for given key.
 	if gsetVal, exist := outerShard.items[key]; exist { // inner gset exists in cmap for key
 		if mySet, okSet := gsetVal.(*mapset.Set); okSet { // convert
@@ -360,8 +395,12 @@ func (m *NestedGSet) PopGSet(key string) (*mapset.Set, bool) {
 }
 
 // PopGSetNoLock deletes key and returns inner gset in cmap for key
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use PopGSet on the handle given by WithShard instead.
 func (m *NestedGSet) PopGSetNoLock(key string) (*mapset.Set, bool) {
 	outerShard := m._cmap.GetShard(key)
+	assertWriteLocked(&outerShard.RWMutex)
 
 	// Get item from shard for given key.
 	if gsetVal, exist := outerShard.items[key]; exist { // inner gset exists in cmap for key
@@ -377,7 +416,7 @@ func (m *NestedGSet) PopGSetNoLock(key string) (*mapset.Set, bool) {
 func (m *NestedGSet) PopStrValues(key string) ([]string, bool) {
 	outerShard := m._cmap.GetShard(key)
 	outerShard.Lock()
-	outerShard.Unlock()
+	defer outerShard.Unlock()
 	// Get item from shard for given key.
 	if gsetVal, exist := outerShard.items[key]; exist { // inner gset exists in cmap for key
 		if mySet, okSet := gsetVal.(*mapset.Set); okSet { // convert
@@ -395,8 +434,12 @@ func (m *NestedGSet) PopStrValues(key string) ([]string, bool) {
 }
 
 // PopStrValuesNoLock deletes key and returns []string values of inner gset in cmap for key
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use PopStrValues on the handle given by WithShard instead.
 func (m *NestedGSet) PopStrValuesNoLock(key string) ([]string, bool) {
 	outerShard := m._cmap.GetShard(key)
+	assertWriteLocked(&outerShard.RWMutex)
 	// Get item from shard for given key.
 	if gsetVal, exist := outerShard.items[key]; exist { // inner gset exists in cmap for key
 		if mySet, okSet := gsetVal.(*mapset.Set); okSet { // convert
@@ -429,10 +472,18 @@ func (m *NestedGSet) GetValues(key string) ([]interface{}, bool) {
 
 // GetValuesNoLock Get list of values in inner gset for key
 // CMap<[<key, GSet1[val1,val2]>, <key2, GSet2[val1,val2]>,... ] > , get val1, val2 for key
+//
+// Deprecated: despite its name it read locks the shard, like GetValues.
+// Use GetValues, or GetValues on the handle given by WithShard or ReadShard.
 func (m *NestedGSet) GetValuesNoLock(key string) ([]interface{}, bool) {
 	outerShard := m._cmap.GetShard(key)
 	outerShard.RLock()
 	defer outerShard.RUnlock()
+	return getValues(outerShard, key)
+}
+
+// getValues Get list of values in inner gset for key, shard must be locked
+func getValues(outerShard *Shard[string, interface{}], key string) ([]interface{}, bool) {
 	if gsetVal, exist := outerShard.items[key]; exist { // inner gset exists in cmap for key
 		if mySet, okSet := gsetVal.(*mapset.Set); okSet {
 			return (*mySet).ToSlice(), true // return slice of values in gset for key
@@ -463,11 +514,20 @@ func (m *NestedGSet) GetStrValues(key string) ([]string, bool) {
 
 // GetStrValuesNoLock Get list of values in inner gset for key
 // CMap<[<key, GSet1[val1,val2]>, <key2, GSet2[val1,val2]>,... ] > , get val1, val2 for key
+//
+// Deprecated: despite its name it read locks the shard, like GetStrValues.
+// Use GetStrValues, or GetStrValues on the handle given by WithShard or
+// ReadShard.
 func (m *NestedGSet) GetStrValuesNoLock(key string) ([]string, bool) {
 	outerShard := m._cmap.GetShard(key)
 	// Get item from shard for given key.
 	outerShard.RLock()
 	defer outerShard.RUnlock()
+	return getStrValues(outerShard, key)
+}
+
+// getStrValues Get list of values in inner gset for key, shard must be locked
+func getStrValues(outerShard *Shard[string, interface{}], key string) ([]string, bool) {
 	if gsetVal, exist := outerShard.items[key]; exist { // inner gset exists in cmap for key
 		if mySet, okSet := gsetVal.(*mapset.Set); okSet { // convert
 			result := make([]string, 0, (*mySet).Cardinality())
@@ -481,3 +541,93 @@ func (m *NestedGSet) GetStrValuesNoLock(key string) ([]string, bool) {
 	}
 	return nil, false
 }
+
+// LockedGSet is a handle on a locked shard of a NestedGSet, given to the
+// callbacks of WithShard and ReadShard. It is only valid during the callback,
+// and only for keys that belong to the locked shard.
+type LockedGSet struct {
+	m *NestedGSet
+	s *LockedShard[string, interface{}]
+}
+
+// WithShard write locks the shard under given key and calls fn with a handle
+// on it, see Map.WithShard.
+func (m *NestedGSet) WithShard(key string, fn func(s *LockedGSet)) {
+	m._cmap.WithShard(key, func(s *LockedShard[string, interface{}]) {
+		fn(&LockedGSet{m: m, s: s})
+	})
+}
+
+// ReadShard read locks the shard under given key and calls fn with a handle
+// on it. Write operations of the handle panic.
+func (m *NestedGSet) ReadShard(key string, fn func(s *LockedGSet)) {
+	m._cmap.ReadShard(key, func(s *LockedShard[string, interface{}]) {
+		fn(&LockedGSet{m: m, s: s})
+	})
+}
+
+// SetValue set value into inner set, false if gset already has it
+func (s *LockedGSet) SetValue(key string, value interface{}) bool {
+	s.s.check(key, true)
+	return s.m.SetValueNoLock(key, value)
+}
+
+// SetMultiValues set list of values into inner gset
+func (s *LockedGSet) SetMultiValues(key string, values []interface{}) {
+	s.s.check(key, true)
+	setMultiValues(s.s.shard, key, values)
+}
+
+// SetMultiStrValues set list of values into inner gset
+func (s *LockedGSet) SetMultiStrValues(key string, values []string) {
+	s.s.check(key, true)
+	s.m.SetMultiStrValuesNoLock(key, values)
+}
+
+// HasValue check if inner gset has value
+func (s *LockedGSet) HasValue(key string, value interface{}) bool {
+	s.s.check(key, false)
+	return s.m.HasValueNoLock(key, value)
+}
+
+// DeleteValue delete one value in inner gset for key, clear empty gset
+func (s *LockedGSet) DeleteValue(key string, value interface{}) {
+	s.s.check(key, true)
+	s.m.DeleteValueNoLock(key, value)
+}
+
+// DeleteMultipleValues delete list of values in inner gset for key, clear empty gset
+func (s *LockedGSet) DeleteMultipleValues(key string, values []interface{}) {
+	s.s.check(key, true)
+	deleteMultipleValues(s.s.shard, key, values)
+}
+
+// GetGSet returns inner gset for key
+func (s *LockedGSet) GetGSet(key string) (*mapset.Set, bool) {
+	s.s.check(key, false)
+	return s.m.GetGSetNoLock(key)
+}
+
+// PopGSet deletes key and returns its inner gset
+func (s *LockedGSet) PopGSet(key string) (*mapset.Set, bool) {
+	s.s.check(key, true)
+	return s.m.PopGSetNoLock(key)
+}
+
+// PopStrValues deletes key and returns the []string values of its inner gset
+func (s *LockedGSet) PopStrValues(key string) ([]string, bool) {
+	s.s.check(key, true)
+	return s.m.PopStrValuesNoLock(key)
+}
+
+// GetValues Get list of values in inner gset for key
+func (s *LockedGSet) GetValues(key string) ([]interface{}, bool) {
+	s.s.check(key, false)
+	return getValues(s.s.shard, key)
+}
+
+// GetStrValues Get list of string values in inner gset for key
+func (s *LockedGSet) GetStrValues(key string) ([]string, bool) {
+	s.s.check(key, false)
+	return getStrValues(s.s.shard, key)
+}
@@ -6,13 +6,13 @@ import (
 
 // NestedQueue Cmap(key,<queue>)
 type NestedQueue struct {
-	_cmap ConcurrentMap
+	_cmap *Map[string, interface{}]
 }
 
 // NewNestedQueue returns Cmap(key,<queue>)
 func NewNestedQueue() *NestedQueue {
 	m := new(NestedQueue)
-	m._cmap = New()
+	m._cmap = NewMap[string, interface{}]()
 	return m
 }
 
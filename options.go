@@ -0,0 +1,82 @@
+package cmap
+
+import "time"
+
+// Option configures a Map created by NewWithOptions.
+type Option func(*options)
+
+// options holds the construction time settings of a map.
+type options struct {
+	shardCount      int
+	hasher          Hasher
+	sharding        interface{} // func(key K) uint32, checked by NewWithOptions
+	onExpire        interface{} // func(key K, v V), checked by NewWithOptions
+	janitorInterval time.Duration
+	capacity        int
+	shardCapacity   int
+	policy          EvictionPolicy
+	onEvict         interface{} // func(key K, v V, reason EvictReason), checked by NewWithOptions
+	equal           interface{} // func(a, b V) bool, checked by NewWithOptions
+	metrics         bool
+	autoGrow        int
+	maxShards       int         // cap of WithAutoGrow
+	keyOrder        interface{} // func(a, b K) int, checked by NewWithOptions
+	prefixSep       string
+	prefixSegments  int
+	readMostly      bool
+}
+
+func defaultOptions() options {
+	return options{shardCount: SHARD_COUNT, maxShards: defaultMaxShards}
+}
+
+// WithShardCount sets the number of shards of the map. The count is fixed for
+// the lifetime of the map, regardless of later changes to SHARD_COUNT.
+func WithShardCount(n int) Option {
+	return func(o *options) {
+		o.shardCount = n
+	}
+}
+
+// NewWithOptions creates a new concurrent map configured by opts.
+func NewWithOptions[K comparable, V any](opts ...Option) *Map[K, V] {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newFromOptions[K, V](o)
+}
+
+// newFromOptions creates a new concurrent map configured by o.
+func newFromOptions[K comparable, V any](o options) *Map[K, V] {
+	if o.shardCount <= 0 {
+		panic("cmap: shard count must be positive")
+	}
+	m := &Map[K, V]{}
+	m.init(o)
+	return m
+}
+
+// init configures the zero map m with o.
+func (m *Map[K, V]) init(o options) {
+	checkReadMostly(&o)
+	checkAutoGrow(&o)
+	m.sharding, m.hasher = shardingFromOptions[K](&o)
+	m.opts = o
+	m.gate.cond.L = &m.gate.mu
+	m.evictionFromOptions(&o)
+	m.casFromOptions(&o)
+	m.orderFromOptions(&o)
+	shards := m.newShards(o.shardCount)
+	m.configureShards(shards)
+	m.table.Store(&shardTable[K, V]{shards: shards})
+	m.ttlFromOptions(&o) // last, it may start the janitor
+}
+
+// configureShards applies the per shard options to new shards.
+func (m *Map[K, V]) configureShards(shards []*Shard[K, V]) {
+	m.configureEviction(shards)
+	m.configureMetrics(shards)
+	m.configureOrder(shards)
+	m.configureReadMostly(shards)
+}
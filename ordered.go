@@ -0,0 +1,317 @@
+package cmap
+
+import (
+	"container/heap"
+	"fmt"
+	"iter"
+	"math/rand/v2"
+)
+
+// WithKeyOrder keeps the keys of every shard sorted by cmp, which returns a
+// negative number, zero or a positive number like cmp.Compare, so that
+// RangeKeys, Ascend, Descend and Seek can walk the map in key order. Writes
+// pay O(log n) to maintain the order. NewWithOptions panics if K does not
+// match the map.
+//
+// The order is not maintained by the nested map types of this package.
+func WithKeyOrder[K any](cmp func(a, b K) int) Option {
+	return func(o *options) {
+		o.keyOrder = cmp
+	}
+}
+
+// orderFromOptions applies WithKeyOrder to a new map.
+func (m *Map[K, V]) orderFromOptions(o *options) {
+	if o.keyOrder == nil {
+		return
+	}
+	cmp, ok := o.keyOrder.(func(a, b K) int)
+	if !ok {
+		panic(fmt.Sprintf("cmap: key order %T does not match key type", o.keyOrder))
+	}
+	m.keyCmp = cmp
+}
+
+// configureOrder gives new shards a sorted index if the map is ordered.
+func (m *Map[K, V]) configureOrder(shards []*Shard[K, V]) {
+	if m.keyCmp == nil {
+		return
+	}
+	for _, shard := range shards {
+		shard.index = newSkipList(m.keyCmp)
+	}
+}
+
+const skipMaxLevel = 24
+
+type skipNode[K any] struct {
+	key  K
+	prev *skipNode[K] // nil for the first node
+	next []*skipNode[K]
+}
+
+// skipList is the sorted index of the keys of a shard.
+type skipList[K any] struct {
+	cmp   func(a, b K) int
+	head  skipNode[K]
+	tail  *skipNode[K]
+	level int
+}
+
+func newSkipList[K any](cmp func(a, b K) int) *skipList[K] {
+	l := &skipList[K]{cmp: cmp, level: 1}
+	l.head.next = make([]*skipNode[K], skipMaxLevel)
+	return l
+}
+
+// search returns the last node before key, or before or at key if inclusive,
+// which is the head when there is none. If preds is not nil it receives the
+// last such node of every level.
+func (l *skipList[K]) search(key K, inclusive bool, preds []*skipNode[K]) *skipNode[K] {
+	n := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for next := n.next[i]; next != nil; next = n.next[i] {
+			c := l.cmp(next.key, key)
+			if c > 0 || c == 0 && !inclusive {
+				break
+			}
+			n = next
+		}
+		if preds != nil {
+			preds[i] = n
+		}
+	}
+	return n
+}
+
+func (l *skipList[K]) insert(key K) {
+	var preds [skipMaxLevel]*skipNode[K]
+	pred := l.search(key, false, preds[:])
+	if next := pred.next[0]; next != nil && l.cmp(next.key, key) == 0 {
+		return
+	}
+	level := 1
+	for level < skipMaxLevel && rand.Uint32()&3 == 0 {
+		level++
+	}
+	for ; l.level < level; l.level++ {
+		preds[l.level] = &l.head
+	}
+	n := &skipNode[K]{key: key, next: make([]*skipNode[K], level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = preds[i].next[i]
+		preds[i].next[i] = n
+	}
+	if pred != &l.head {
+		n.prev = pred
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	} else {
+		l.tail = n
+	}
+}
+
+func (l *skipList[K]) remove(key K) {
+	var preds [skipMaxLevel]*skipNode[K]
+	l.search(key, false, preds[:])
+	n := preds[0].next[0]
+	if n == nil || l.cmp(n.key, key) != 0 {
+		return
+	}
+	for i := range n.next {
+		preds[i].next[i] = n.next[i]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+}
+
+// ceil returns the first node at or after key.
+func (l *skipList[K]) ceil(key K) *skipNode[K] {
+	return l.search(key, false, nil).next[0]
+}
+
+// higher returns the first node after key.
+func (l *skipList[K]) higher(key K) *skipNode[K] {
+	return l.search(key, true, nil).next[0]
+}
+
+// lower returns the last node before key.
+func (l *skipList[K]) lower(key K) *skipNode[K] {
+	if n := l.search(key, false, nil); n != &l.head {
+		return n
+	}
+	return nil
+}
+
+// keyRange is the part of the key space an ordered walk covers, from is
+// inclusive and to exclusive.
+type keyRange[K any] struct {
+	from, to       K
+	hasFrom, hasTo bool
+}
+
+// orderedBatch is the number of entries a cursor copies per shard lock.
+const orderedBatch = 64
+
+// orderedCursor walks the live entries of one shard in key order, copying
+// them in batches so the shard is not locked while they are consumed.
+type orderedCursor[K comparable, V any] struct {
+	shard   *Shard[K, V]
+	buf     []Entry[K, V]
+	pos     int
+	last    K // last key visited, where the next batch resumes
+	started bool
+	done    bool
+}
+
+// fill copies the next batch of entries and reports whether there are any.
+func (c *orderedCursor[K, V]) fill(r *keyRange[K], desc bool) bool {
+	c.buf, c.pos = c.buf[:0], 0
+	if c.done {
+		return false
+	}
+	s := c.shard
+	s.RLock()
+	defer s.RUnlock()
+	l := s.index
+	var n *skipNode[K]
+	switch {
+	case c.started && desc:
+		n = l.lower(c.last)
+	case c.started:
+		n = l.higher(c.last)
+	case desc && r.hasTo:
+		n = l.lower(r.to)
+	case desc:
+		n = l.tail
+	case r.hasFrom:
+		n = l.ceil(r.from)
+	default:
+		n = l.head.next[0]
+	}
+	c.started = true
+	now := s.now()
+	for ; n != nil && len(c.buf) < orderedBatch; n = c.step(n, desc) {
+		if desc && r.hasFrom && l.cmp(n.key, r.from) < 0 || !desc && r.hasTo && l.cmp(n.key, r.to) >= 0 {
+			n = nil
+			break
+		}
+		c.last = n.key
+		if !s.expiredAt(n.key, now) {
+			c.buf = append(c.buf, Entry[K, V]{n.key, s.items[n.key]})
+		}
+	}
+	c.done = n == nil
+	return len(c.buf) > 0 || !c.done && c.fill(r, desc)
+}
+
+func (c *orderedCursor[K, V]) step(n *skipNode[K], desc bool) *skipNode[K] {
+	if desc {
+		return n.prev
+	}
+	return n.next[0]
+}
+
+// cursorHeap merges the cursors of the shards by their current key.
+type cursorHeap[K comparable, V any] struct {
+	cursors []*orderedCursor[K, V]
+	cmp     func(a, b K) int
+	desc    bool
+}
+
+func (h *cursorHeap[K, V]) Len() int { return len(h.cursors) }
+
+func (h *cursorHeap[K, V]) Less(i, j int) bool {
+	c := h.cmp(h.cursors[i].buf[h.cursors[i].pos].Key, h.cursors[j].buf[h.cursors[j].pos].Key)
+	if h.desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+func (h *cursorHeap[K, V]) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *cursorHeap[K, V]) Push(x interface{}) {
+	h.cursors = append(h.cursors, x.(*orderedCursor[K, V]))
+}
+
+func (h *cursorHeap[K, V]) Pop() interface{} {
+	c := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return c
+}
+
+// walk calls fn for the live entries in r in key order, descending if desc,
+// until fn returns false. Shards are locked one batch at a time, so entries
+// written during the walk may or may not be seen.
+func (m *Map[K, V]) walk(r keyRange[K], desc bool, fn func(key K, v V) bool) {
+	m.mustBeOrdered()
+	shards := m.pinShards()
+	defer m.unpinShards()
+	h := &cursorHeap[K, V]{cmp: m.keyCmp, desc: desc}
+	for _, shard := range shards {
+		c := &orderedCursor[K, V]{shard: shard}
+		if c.fill(&r, desc) {
+			h.cursors = append(h.cursors, c)
+		}
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		c := h.cursors[0]
+		t := c.buf[c.pos]
+		if !fn(t.Key, t.Val) {
+			return
+		}
+		if c.pos++; c.pos < len(c.buf) || c.fill(&r, desc) {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+}
+
+func (m *Map[K, V]) mustBeOrdered() {
+	if m.keyCmp == nil {
+		panic("cmap: ordered iteration needs a map created WithKeyOrder")
+	}
+}
+
+// RangeKeys returns the keys from from, inclusive, to to, exclusive, in
+// ascending order. It panics unless the map was created WithKeyOrder.
+func (m *Map[K, V]) RangeKeys(from, to K) []K {
+	var keys []K
+	m.walk(keyRange[K]{from: from, to: to, hasFrom: true, hasTo: true}, false, func(key K, v V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Ascend calls fn for every entry in ascending key order until fn returns
+// false. The per shard sorted streams are merged while fn runs, copying a
+// few entries of a shard at a time, so fn may use the map, but Resize waits
+// for Ascend to return. It panics unless the map was created WithKeyOrder.
+func (m *Map[K, V]) Ascend(fn func(key K, v V) bool) {
+	m.walk(keyRange[K]{}, false, fn)
+}
+
+// Descend is Ascend in descending key order.
+func (m *Map[K, V]) Descend(fn func(key K, v V) bool) {
+	m.walk(keyRange[K]{}, true, fn)
+}
+
+// Seek returns an iterator over the entries from key, inclusive, in
+// ascending key order. It panics unless the map was created WithKeyOrder.
+func (m *Map[K, V]) Seek(key K) iter.Seq2[K, V] {
+	m.mustBeOrdered()
+	return func(yield func(K, V) bool) {
+		m.walk(keyRange[K]{from: key, hasFrom: true}, false, yield)
+	}
+}
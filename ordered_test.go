@@ -0,0 +1,128 @@
+package cmap
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestKeyOrder(t *testing.T) {
+	m := NewWithOptions[int, int](WithShardCount(8), WithKeyOrder(cmp.Compare[int]))
+	var want []int
+	for _, i := range rand.Perm(1000) {
+		m.Set(i, i*10)
+		if i%3 == 0 {
+			want = append(want, i)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if i%3 != 0 {
+			m.Remove(i)
+		}
+	}
+	slices.Sort(want)
+
+	var got []int
+	m.Ascend(func(key, v int) bool {
+		if v != key*10 {
+			t.Fatal("Ascend should pass the values.", key, v)
+		}
+		got = append(got, key)
+		return true
+	})
+	if !slices.Equal(got, want) {
+		t.Fatal("Ascend should visit the keys in order.", got)
+	}
+
+	got = got[:0]
+	m.Descend(func(key, v int) bool {
+		got = append(got, key)
+		return true
+	})
+	slices.Reverse(want)
+	if !slices.Equal(got, want) {
+		t.Fatal("Descend should visit the keys in reverse order.", got)
+	}
+
+	if keys := m.RangeKeys(100, 112); !slices.Equal(keys, []int{102, 105, 108, 111}) {
+		t.Error("RangeKeys should return the keys in [from, to).", keys)
+	}
+	if keys := m.RangeKeys(5, 5); len(keys) != 0 {
+		t.Error("an empty range should have no keys.", keys)
+	}
+
+	got = got[:0]
+	for k := range m.Seek(994) {
+		got = append(got, k)
+	}
+	if !slices.Equal(got, []int{996, 999}) {
+		t.Error("Seek should start at the first key not before key.", got)
+	}
+}
+
+func TestKeyOrderStop(t *testing.T) {
+	m := NewWithOptions[string, int](WithKeyOrder(cmp.Compare[string]))
+	for i := 0; i < 500; i++ {
+		m.Set(fmt.Sprintf("k%03d", i), i)
+	}
+	n := 0
+	m.Descend(func(key string, v int) bool {
+		n++
+		return v > 400
+	})
+	if n != 100 {
+		t.Error("returning false should stop Descend.", n)
+	}
+	for k := range m.Seek("k250") {
+		m.Remove(k) // the map may be used while iterating
+	}
+	if m.Count() != 250 {
+		t.Error(m.Count())
+	}
+}
+
+func TestKeyOrderTTL(t *testing.T) {
+	now := fakeClock(t)
+	m := NewWithOptions[int, int](WithKeyOrder(cmp.Compare[int]))
+	m.Set(1, 1)
+	m.SetWithTTL(2, 2, time.Second)
+	m.Set(3, 3)
+	*now += int64(time.Second)
+	if keys := m.RangeKeys(0, 10); !slices.Equal(keys, []int{1, 3}) {
+		t.Error("expired keys should be skipped.", keys)
+	}
+}
+
+func TestKeyOrderResize(t *testing.T) {
+	m := NewWithOptions[int, int](WithShardCount(2), WithKeyOrder(cmp.Compare[int]))
+	for i := 0; i < 300; i++ {
+		m.Set(i, i)
+	}
+	m.Resize(16)
+	m.Set(300, 300)
+	keys := m.RangeKeys(0, 1000)
+	if len(keys) != 301 || !slices.IsSorted(keys) {
+		t.Error("Resize should keep the order.", len(keys))
+	}
+}
+
+func TestKeyOrderMisuse(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Ascend should panic without WithKeyOrder.")
+		}
+	}()
+	NewMap[string, int]().Ascend(func(string, int) bool { return true })
+}
+
+func TestKeyOrderTypeMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("a key order of another type should panic.")
+		}
+	}()
+	NewWithOptions[string, int](WithKeyOrder(cmp.Compare[int]))
+}
@@ -0,0 +1,79 @@
+package cmap
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// eachShardParallel calls fn for every shard, with the shard read locked, on
+// up to workers goroutines, GOMAXPROCS if workers is not positive. i is the
+// position of the shard in shards.
+func eachShardParallel[K comparable, V any](shards []*Shard[K, V], workers int, fn func(i int, shard *Shard[K, V])) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(shards))
+	var (
+		next atomic.Int64
+		wg   sync.WaitGroup
+	)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < len(shards); i = int(next.Add(1) - 1) {
+				shard := shards[i]
+				shard.RLock()
+				fn(i, shard)
+				shard.RUnlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ParallelRange calls fn for every entry, processing the shards on up to
+// workers goroutines, GOMAXPROCS if workers is not positive. fn runs
+// concurrently for entries of different shards, while the shard of the
+// entry is read locked, so it must be safe for concurrent use and must not
+// use the map.
+func (m *Map[K, V]) ParallelRange(workers int, fn func(key K, v V)) {
+	shards := m.pinShards()
+	defer m.unpinShards()
+	eachShardParallel(shards, workers, func(_ int, shard *Shard[K, V]) {
+		now := shard.now()
+		for key, v := range shard.items {
+			if !shard.expiredAt(key, now) {
+				fn(key, v)
+			}
+		}
+	})
+}
+
+// Reduce folds the entries of each shard with mapFn, starting from zero,
+// then merges the results of the shards, in shard order, with combineFn.
+// The shards are processed concurrently, up to GOMAXPROCS at a time, each
+// under its read lock, so mapFn must not use the map. Every shard starts
+// from the same zero, so mapFn must not modify a map or slice held by zero;
+// start from nil instead.
+func Reduce[K comparable, V, R any](m *Map[K, V], zero R, mapFn func(acc R, key K, v V) R, combineFn func(a, b R) R) R {
+	shards := m.pinShards()
+	defer m.unpinShards()
+	partial := make([]R, len(shards))
+	eachShardParallel(shards, 0, func(i int, shard *Shard[K, V]) {
+		acc := zero
+		now := shard.now()
+		for key, v := range shard.items {
+			if !shard.expiredAt(key, now) {
+				acc = mapFn(acc, key, v)
+			}
+		}
+		partial[i] = acc
+	})
+	result := partial[0]
+	for _, p := range partial[1:] {
+		result = combineFn(result, p)
+	}
+	return result
+}
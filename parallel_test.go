@@ -0,0 +1,64 @@
+package cmap
+
+import (
+	"maps"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelRange(t *testing.T) {
+	m := NewMap[int, int]()
+	for i := 1; i <= 1000; i++ {
+		m.Set(i, i)
+	}
+	for _, workers := range []int{0, 1, 4, 100} {
+		var sum, n atomic.Int64
+		m.ParallelRange(workers, func(key, v int) {
+			sum.Add(int64(v))
+			n.Add(1)
+		})
+		if n.Load() != 1000 || sum.Load() != 500500 {
+			t.Error("ParallelRange should visit every entry once.", workers, n.Load(), sum.Load())
+		}
+	}
+}
+
+func TestReduce(t *testing.T) {
+	now := fakeClock(t)
+	m := NewMap[string, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	m.SetWithTTL("expired", 1000, time.Second)
+	*now += int64(time.Second)
+
+	sum := Reduce(m, 0, func(acc int, key string, v int) int {
+		return acc + v
+	}, func(a, b int) int {
+		return a + b
+	})
+	if sum != 4950 {
+		t.Error("Reduce should sum the live entries.", sum)
+	}
+
+	hist := Reduce(m, map[int]int(nil), func(acc map[int]int, key string, v int) map[int]int {
+		if acc == nil {
+			acc = make(map[int]int)
+		}
+		acc[v%3]++
+		return acc
+	}, func(a, b map[int]int) map[int]int {
+		if a == nil {
+			return b
+		}
+		for k, n := range b {
+			a[k] += n
+		}
+		return a
+	})
+	if !maps.Equal(hist, map[int]int{0: 34, 1: 33, 2: 33}) {
+		t.Error("Reduce should combine the shard results.", hist)
+	}
+}
@@ -0,0 +1,274 @@
+package cmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Snapshot file format, version 1. All integers are little endian.
+//
+//	header:  "CMAP" | version uint16 | section count uint32
+//	section: entry count uint32 | payload length uint64 | payload | crc32 (IEEE) of payload
+//	payload: entry count times uvarint key length | key | uvarint value length | value | varint deadline
+//
+// The deadline is the TTL deadline of the entry in unix nanoseconds, 0 if it
+// has none. SaveTo writes one section per shard.
+const (
+	persistMagic   = "CMAP"
+	persistVersion = 1
+)
+
+var (
+	// ErrBadFormat is returned by LoadFrom for input that is not a map snapshot.
+	ErrBadFormat = errors.New("cmap: not a map snapshot")
+	// ErrChecksum is returned by LoadFrom when a shard section is corrupted.
+	ErrChecksum = errors.New("cmap: snapshot checksum mismatch")
+)
+
+// ValueCodec encodes keys or values of type T for SaveTo and LoadFrom.
+type ValueCodec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// GobCodec is a ValueCodec using encoding/gob.
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&v)
+	return buf.Bytes(), err
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// JSONCodec is a ValueCodec using encoding/json.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// Codec encodes the entries of a map. Key defaults to JSONCodec and
+// Value to GobCodec when nil.
+type Codec[K comparable, V any] struct {
+	Key   ValueCodec[K]
+	Value ValueCodec[V]
+}
+
+func (c Codec[K, V]) withDefaults() Codec[K, V] {
+	if c.Key == nil {
+		c.Key = JSONCodec[K]{}
+	}
+	if c.Value == nil {
+		c.Value = GobCodec[V]{}
+	}
+	return c
+}
+
+// SaveTo writes the map to w in a versioned binary format, one checksummed
+// section per shard. Each shard is copied under its read lock and encoded
+// after the lock is released, so writers are blocked for one shard copy at a
+// time. The result is consistent per shard, use Snapshot first if it must be
+// consistent across shards. Entries keep their TTL deadlines.
+func (m *Map[K, V]) SaveTo(w io.Writer, codec Codec[K, V]) error {
+	codec = codec.withDefaults()
+	shards := m.pinShards()
+	defer m.unpinShards()
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 0, 10)
+	header = append(header, persistMagic...)
+	header = binary.LittleEndian.AppendUint16(header, persistVersion)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(shards)))
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	var (
+		entries []ttlEntry[K, V]
+		payload []byte
+	)
+	for _, shard := range shards {
+		entries = shard.appendLiveTTL(entries[:0])
+		payload = payload[:0]
+		for _, e := range entries {
+			var err error
+			if payload, err = appendEncoded(payload, codec.Key, e.key); err != nil {
+				return err
+			}
+			if payload, err = appendEncoded(payload, codec.Value, e.val); err != nil {
+				return err
+			}
+			payload = binary.AppendVarint(payload, e.deadline)
+		}
+		if err := writeSection(bw, len(entries), payload); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// LoadFrom reads a snapshot written by SaveTo and sets its entries in m.
+// The snapshot may come from a map with another shard count or hasher.
+// Sections are decoded and inserted in parallel. On error the entries of
+// the sections loaded so far stay in the map. Entries whose TTL passed since
+// they were saved are skipped.
+func (m *Map[K, V]) LoadFrom(r io.Reader, codec Codec[K, V]) error {
+	codec = codec.withDefaults()
+	br := bufio.NewReader(r)
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadFormat, err)
+	}
+	if string(header[:4]) != persistMagic {
+		return ErrBadFormat
+	}
+	if v := binary.LittleEndian.Uint16(header[4:]); v != persistVersion {
+		return fmt.Errorf("cmap: unsupported snapshot version %d", v)
+	}
+	sections := binary.LittleEndian.Uint32(header[6:])
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		carrier  panicCarrier
+		sem      = make(chan struct{}, runtime.GOMAXPROCS(0))
+	)
+	fail := func(err error) {
+		errOnce.Do(func() { firstErr = err })
+	}
+	for i := uint32(0); i < sections; i++ {
+		count, payload, err := readSection(br)
+		if err != nil {
+			fail(err)
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			carrier.run(func() {
+				if err := m.loadSection(count, payload, codec); err != nil {
+					fail(err)
+				}
+			})
+		}()
+	}
+	wg.Wait()
+	carrier.rethrow()
+	return firstErr
+}
+
+// loadSection decodes the entries of a section and sets them in m.
+func (m *Map[K, V]) loadSection(count int, payload []byte, codec Codec[K, V]) error {
+	for i := 0; i < count; i++ {
+		var (
+			key      K
+			val      V
+			deadline int64
+			err      error
+		)
+		if key, payload, err = readEncoded(payload, codec.Key); err != nil {
+			return err
+		}
+		if val, payload, err = readEncoded(payload, codec.Value); err != nil {
+			return err
+		}
+		var size int
+		if deadline, size = binary.Varint(payload); size <= 0 {
+			return ErrBadFormat
+		}
+		payload = payload[size:]
+		switch {
+		case deadline == 0:
+			m.Set(key, val)
+		case deadline > nowNano():
+			shard := m.lockKey(key)
+			shard.store(key, val, OpSet)
+			shard.setDeadline(key, deadline)
+			m.unlock(shard)
+		}
+	}
+	if len(payload) != 0 {
+		return ErrBadFormat
+	}
+	return nil
+}
+
+func writeSection(w io.Writer, count int, payload []byte) error {
+	head := make([]byte, 0, 12)
+	head = binary.LittleEndian.AppendUint32(head, uint32(count))
+	head = binary.LittleEndian.AppendUint64(head, uint64(len(payload)))
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, crc32.ChecksumIEEE(payload))
+}
+
+func readSection(r io.Reader) (int, []byte, error) {
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrBadFormat, err)
+	}
+	count := binary.LittleEndian.Uint32(head)
+	length := binary.LittleEndian.Uint64(head[4:])
+	// Grow with the data read, a corrupted length must not allocate up front.
+	buf := bytes.NewBuffer(make([]byte, 0, min(length, 1<<20)))
+	if _, err := io.CopyN(buf, r, int64(length)); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrBadFormat, err)
+	}
+	var sum uint32
+	if err := binary.Read(r, binary.LittleEndian, &sum); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrBadFormat, err)
+	}
+	if crc32.ChecksumIEEE(buf.Bytes()) != sum {
+		return 0, nil, ErrChecksum
+	}
+	return int(count), buf.Bytes(), nil
+}
+
+// appendEncoded appends the length prefixed encoding of v to buf.
+func appendEncoded[T any](buf []byte, codec ValueCodec[T], v T) ([]byte, error) {
+	data, err := codec.Encode(v)
+	if err != nil {
+		return buf, err
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...), nil
+}
+
+// readEncoded decodes a length prefixed value from the front of buf.
+func readEncoded[T any](buf []byte, codec ValueCodec[T]) (T, []byte, error) {
+	var zero T
+	n, size := binary.Uvarint(buf)
+	if size <= 0 || uint64(len(buf)-size) < n {
+		return zero, nil, ErrBadFormat
+	}
+	v, err := codec.Decode(buf[size : size+int(n)])
+	return v, buf[size+int(n):], err
+}
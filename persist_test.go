@@ -0,0 +1,139 @@
+package cmap
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type account struct {
+	Owner   string
+	Balance int
+}
+
+type upperCodec struct{}
+
+func (upperCodec) Encode(v string) ([]byte, error) {
+	return []byte(strings.ToUpper(v)), nil
+}
+
+func (upperCodec) Decode(data []byte) (string, error) {
+	return strings.ToLower(string(data)), nil
+}
+
+func TestSaveLoad(t *testing.T) {
+	codecs := map[string]Codec[string, account]{
+		"default": {},
+		"json":    {Key: JSONCodec[string]{}, Value: JSONCodec[account]{}},
+	}
+	for name, codec := range codecs {
+		m := NewMap[string, account]()
+		for i := 0; i < 1000; i++ {
+			m.Set(strconv.Itoa(i), account{strconv.Itoa(i), i})
+		}
+		var buf bytes.Buffer
+		if err := m.SaveTo(&buf, codec); err != nil {
+			t.Fatal(name, err)
+		}
+
+		// Load into a map with another shard count.
+		loaded := NewWithOptions[string, account](WithShardCount(7))
+		if err := loaded.LoadFrom(&buf, codec); err != nil {
+			t.Fatal(name, err)
+		}
+		if loaded.Count() != 1000 {
+			t.Error(name, "Expecting 1000 elements, got", loaded.Count())
+		}
+		if v, ok := loaded.Get("42"); !ok || v != (account{"42", 42}) {
+			t.Error(name, "wrong value for 42", v)
+		}
+	}
+}
+
+func TestSaveLoadValueCodec(t *testing.T) {
+	m := NewMap[int, string]()
+	m.Set(1, "one")
+	m.Set(2, "two")
+	var buf bytes.Buffer
+	codec := Codec[int, string]{Value: upperCodec{}}
+	if err := m.SaveTo(&buf, codec); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("ONE")) {
+		t.Error("the value codec should be used to encode.")
+	}
+	loaded := NewMap[int, string]()
+	if err := loaded.LoadFrom(&buf, codec); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := loaded.Get(1); v != "one" {
+		t.Error("the value codec should be used to decode, got", v)
+	}
+}
+
+func TestLoadCorrupted(t *testing.T) {
+	m := NewMap[string, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	var buf bytes.Buffer
+	if err := m.SaveTo(&buf, Codec[string, int]{}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-10] ^= 0xff
+	if err := NewMap[string, int]().LoadFrom(bytes.NewReader(corrupted), Codec[string, int]{}); !errors.Is(err, ErrChecksum) {
+		t.Error("Expecting a checksum error, got", err)
+	}
+
+	if err := NewMap[string, int]().LoadFrom(bytes.NewReader(data[:len(data)-3]), Codec[string, int]{}); !errors.Is(err, ErrBadFormat) {
+		t.Error("Expecting a format error for truncated input, got", err)
+	}
+
+	if err := NewMap[string, int]().LoadFrom(strings.NewReader("not a snapshot"), Codec[string, int]{}); !errors.Is(err, ErrBadFormat) {
+		t.Error("Expecting a format error, got", err)
+	}
+}
+
+func TestSaveLoadTTL(t *testing.T) {
+	now := fakeClock(t)
+	m := NewMap[string, int]()
+	m.SetWithTTL("session", 1, time.Minute)
+	m.SetWithTTL("token", 2, time.Hour)
+	m.Set("config", 3)
+	var buf bytes.Buffer
+	if err := m.SaveTo(&buf, Codec[string, int]{}); err != nil {
+		t.Fatal(err)
+	}
+
+	*now += int64(30 * time.Second)
+	loaded := NewMap[string, int]()
+	if err := loaded.LoadFrom(bytes.NewReader(buf.Bytes()), Codec[string, int]{}); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Count() != 3 {
+		t.Error("Expecting 3 elements, got", loaded.Count())
+	}
+	*now += int64(30 * time.Second)
+	if loaded.Has("session") {
+		t.Error("session should expire at the deadline it was saved with.")
+	}
+	if !loaded.Has("token") || !loaded.Has("config") {
+		t.Error("token and config should still be alive.")
+	}
+
+	// Entries whose TTL passed since the save are not loaded.
+	*now += int64(time.Hour)
+	late := NewMap[string, int]()
+	if err := late.LoadFrom(bytes.NewReader(buf.Bytes()), Codec[string, int]{}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := late.Keys(); len(keys) != 1 || keys[0] != "config" {
+		t.Error("only config should be loaded, got", keys)
+	}
+}
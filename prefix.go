@@ -0,0 +1,179 @@
+package cmap
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+)
+
+// WithPrefixSharding places string keys by their first segments only, the
+// part of the key before the segments-th sep, so all keys sharing those
+// segments live in one shard. For keys like "tenant/123/session/abc",
+// WithPrefixSharding("/", 2) keeps every key of a tenant together, and the
+// prefix methods given a prefix of at least two whole segments, such as
+// "tenant/123/", lock only that shard instead of scanning all of them.
+// Keys with fewer segments are placed by the whole key.
+//
+// It wraps the Hasher of the map. NewWithOptions panics if the map keys are
+// not strings or if it is combined with WithShardingFunc or WithAutoGrow.
+func WithPrefixSharding(sep string, segments int) Option {
+	return func(o *options) {
+		o.prefixSep = sep
+		o.prefixSegments = segments
+	}
+}
+
+// prefixHasher hashes the first segments of a key with h.
+type prefixHasher struct {
+	h        Hasher
+	sep      string
+	sepBytes []byte
+	segments int
+}
+
+// cut returns the length of the first segments of key, and whether key has
+// that many segments, each followed by a separator.
+func (p prefixHasher) cut(key string) (int, bool) {
+	n := 0
+	for i := 0; i < p.segments; i++ {
+		if i > 0 {
+			n += len(p.sep)
+		}
+		j := strings.Index(key[n:], p.sep)
+		if j < 0 {
+			return len(key), false
+		}
+		n += j
+	}
+	return n, true
+}
+
+func (p prefixHasher) Hash(key string) uint32 {
+	n, _ := p.cut(key)
+	return p.h.Hash(key[:n])
+}
+
+func (p prefixHasher) HashBytes(key []byte) uint32 {
+	sep := p.sepBytes
+	n := 0
+	for i := 0; i < p.segments; i++ {
+		if i > 0 {
+			n += len(sep)
+		}
+		j := bytes.Index(key[n:], sep)
+		if j < 0 {
+			return p.h.HashBytes(key)
+		}
+		n += j
+	}
+	return p.h.HashBytes(key[:n])
+}
+
+// prefixHash returns the hash of the only shard that may hold keys starting
+// with prefix, if the map is sharded WithPrefixSharding and prefix spans the
+// segments keys are placed by.
+func (m *Map[K, V]) prefixHash(prefix string) (uint32, bool) {
+	p, ok := m.hasher.(prefixHasher)
+	if !ok {
+		return 0, false
+	}
+	n, ok := p.cut(prefix)
+	if !ok {
+		return 0, false
+	}
+	return p.h.Hash(prefix[:n]), true
+}
+
+// forPrefix calls fn with every shard that may hold keys starting with
+// prefix, write locked if write and read locked otherwise.
+func (m *Map[K, V]) forPrefix(prefix string, write bool, fn func(shard *Shard[K, V])) {
+	if reflect.TypeFor[K]().Kind() != reflect.String {
+		panic("cmap: prefix methods need string keys")
+	}
+	run := func(shard *Shard[K, V]) {
+		fn(shard)
+		if write {
+			m.unlock(shard)
+		} else {
+			shard.RUnlock()
+		}
+	}
+	if hash, ok := m.prefixHash(prefix); ok {
+		if write {
+			run(m.lockHash(hash))
+		} else {
+			run(m.rlockHash(hash))
+		}
+		return
+	}
+	shards := m.pinShards()
+	defer m.unpinShards()
+	for _, shard := range shards {
+		if write {
+			shard.Lock()
+		} else {
+			shard.RLock()
+		}
+		run(shard)
+	}
+}
+
+// KeysWithPrefix returns the keys starting with prefix.
+// It panics unless K is a string type.
+func (m *Map[K, V]) KeysWithPrefix(prefix string) []K {
+	var keys []K
+	m.IterPrefix(prefix, func(key K, v V) {
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// IterPrefix calls fn for every entry whose key starts with prefix. Like
+// IterCb, fn runs while the shard of the entry is read locked and must not
+// use the map. It panics unless K is a string type.
+func (m *Map[K, V]) IterPrefix(prefix string, fn IterFunc[K, V]) {
+	m.forPrefix(prefix, false, func(shard *Shard[K, V]) {
+		now := shard.now()
+		for key, v := range shard.items {
+			if strings.HasPrefix(keyString(key), prefix) && !shard.expiredAt(key, now) {
+				fn(key, v)
+			}
+		}
+	})
+}
+
+// CountPrefix returns the number of entries whose key starts with prefix.
+// It panics unless K is a string type.
+func (m *Map[K, V]) CountPrefix(prefix string) int {
+	n := 0
+	m.IterPrefix(prefix, func(K, V) {
+		n++
+	})
+	return n
+}
+
+// RemovePrefix removes the entries whose key starts with prefix and returns
+// how many it removed. Expired entries found on the way are expired as
+// DeleteExpired would. It panics unless K is a string type.
+func (m *Map[K, V]) RemovePrefix(prefix string) int {
+	removed := 0
+	var keys []K
+	m.forPrefix(prefix, true, func(shard *Shard[K, V]) {
+		keys = keys[:0]
+		for key := range shard.items {
+			if strings.HasPrefix(keyString(key), prefix) {
+				keys = append(keys, key)
+			}
+		}
+		now := shard.now()
+		for _, key := range keys {
+			if shard.expiredAt(key, now) {
+				shard.expire(key)
+			} else {
+				shard.drop(key, OpRemove)
+				removed++
+			}
+		}
+	})
+	return removed
+}
@@ -0,0 +1,135 @@
+package cmap
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func fillTenants(m *Map[string, int]) {
+	for tenant := 0; tenant < 10; tenant++ {
+		for session := 0; session < 20; session++ {
+			m.Set(fmt.Sprintf("tenant/%d/session/%d", tenant, session), session)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithPrefixSharding("/", 2)}} {
+		m := NewWithOptions[string, int](opts...)
+		fillTenants(m)
+		m.Set("tenant/10/session/0", 0)
+
+		if n := m.CountPrefix("tenant/1/"); n != 20 {
+			t.Error("CountPrefix should count the matching keys.", n)
+		}
+		if n := m.CountPrefix("tenant/1"); n != 21 {
+			t.Error("a prefix may end inside a segment.", n)
+		}
+		keys := m.KeysWithPrefix("tenant/3/session/1")
+		slices.Sort(keys)
+		want := []string{"tenant/3/session/1"}
+		for i := 10; i < 20; i++ {
+			want = append(want, fmt.Sprintf("tenant/3/session/%d", i))
+		}
+		slices.Sort(want)
+		if !slices.Equal(keys, want) {
+			t.Error("KeysWithPrefix should return the matching keys.", keys)
+		}
+		sum := 0
+		m.IterPrefix("tenant/4/", func(key string, v int) {
+			sum += v
+		})
+		if sum != 190 {
+			t.Error("IterPrefix should visit the matching entries.", sum)
+		}
+
+		if n := m.RemovePrefix("tenant/2/"); n != 20 {
+			t.Error("RemovePrefix should report the removed entries.", n)
+		}
+		if m.CountPrefix("tenant/2/") != 0 || m.Count() != 181 {
+			t.Error("RemovePrefix should remove only the matching entries.", m.Count())
+		}
+	}
+}
+
+func TestPrefixSharding(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(16), WithPrefixSharding("/", 2))
+	fillTenants(m)
+	for tenant := 0; tenant < 10; tenant++ {
+		prefix := fmt.Sprintf("tenant/%d/", tenant)
+		shard := m.GetShard(prefix + "session/0")
+		for _, key := range m.KeysWithPrefix(prefix) {
+			if m.GetShard(key) != shard {
+				t.Fatal("the keys of a tenant should share a shard.", key)
+			}
+		}
+	}
+	// *Bytes methods must find the shard the string methods use.
+	m.SetBytes([]byte("tenant/7/session/99"), 99)
+	if v, ok := m.Get("tenant/7/session/99"); !ok || v != 99 {
+		t.Error("SetBytes and Get should agree on the shard.")
+	}
+	if v, ok := m.GetBytes([]byte("tenant/7/session/3")); !ok || v != 3 {
+		t.Error("GetBytes and Set should agree on the shard.")
+	}
+}
+
+func TestPrefixSingleShard(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(4), WithPrefixSharding("/", 1))
+	m.Set("a/1", 1)
+	m.Set("b/1", 1)
+	other := m.GetShard("b/1")
+	if other == m.GetShard("a/1") {
+		t.Skip("both prefixes share a shard")
+	}
+	other.Lock()
+	defer other.Unlock()
+	// Only the shard of "a" is locked, so this does not wait for other.
+	if m.CountPrefix("a/") != 1 || m.RemovePrefix("a/") != 1 {
+		t.Error("prefix methods should work on the shard of the prefix.")
+	}
+}
+
+func TestRemovePrefixExpired(t *testing.T) {
+	now := fakeClock(t)
+	var expired []string
+	m := NewWithOptions[string, int](WithOnExpire(func(key string, v int) {
+		expired = append(expired, key)
+	}))
+	m.SetWithTTL("a/1", 1, time.Second)
+	m.Set("a/2", 2)
+	*now += int64(time.Second)
+	if n := m.RemovePrefix("a/"); n != 1 {
+		t.Error("expired entries should not count as removed.", n)
+	}
+	if len(expired) != 1 || m.Count() != 0 {
+		t.Error("expired entries should be expired.", expired, m.Count())
+	}
+}
+
+func TestPrefixNeedsStringKeys(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("WithPrefixSharding should panic for int keys.")
+		}
+	}()
+	NewWithOptions[int, int](WithPrefixSharding("/", 1))
+}
+
+func TestPrefixRejectsAutoGrow(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"WithPrefixSharding": WithPrefixSharding("/", 1),
+		"WithShardingFunc":   WithShardingFunc(func(key string) uint32 { return uint32(len(key)) }),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error(name, "combined with WithAutoGrow should panic.")
+				}
+			}()
+			NewWithOptions[string, int](opt, WithAutoGrow(100))
+		}()
+	}
+}
@@ -0,0 +1,71 @@
+package cmap
+
+import "maps"
+
+// WithReadMostly makes Get and Has lock free. Every shard publishes a copy
+// of its entries, which writes replace under the write lock, once per lock
+// hold. Reads never wait for writers, while a write costs a copy of its
+// shard, so the mode suits maps written rarely and read from many
+// goroutines. It can not be combined with a capacity, since reads would no
+// longer reach the eviction policy.
+func WithReadMostly() Option {
+	return func(o *options) {
+		o.readMostly = true
+	}
+}
+
+// readSnapshot is the copy of the entries of a shard published for lock
+// free reads.
+type readSnapshot[K comparable, V any] struct {
+	items   map[K]V
+	expires map[K]int64
+}
+
+// checkReadMostly panics if WithReadMostly is combined with a capacity.
+func checkReadMostly(o *options) {
+	if o.readMostly && (o.capacity > 0 || o.shardCapacity > 0) {
+		panic("cmap: WithReadMostly can not be combined with a capacity")
+	}
+}
+
+// configureReadMostly publishes the first, empty, snapshot of new shards.
+func (m *Map[K, V]) configureReadMostly(shards []*Shard[K, V]) {
+	if !m.opts.readMostly {
+		return
+	}
+	for _, shard := range shards {
+		shard.publishSnapshot()
+	}
+}
+
+// publishSnapshot replaces the snapshot of the shard with a copy of its
+// entries. Write lock must be held.
+func (s *Shard[K, V]) publishSnapshot() {
+	s.snap.Store(&readSnapshot[K, V]{items: maps.Clone(s.items), expires: maps.Clone(s.expires)})
+}
+
+// loadSnapshot returns the snapshot of the shard owning keys with the given
+// hash. A shard is only marked moved under its write lock, before its keys
+// are written anywhere else, so a snapshot loaded before the flag is seen
+// unset is current.
+func (m *Map[K, V]) loadSnapshot(hash uint32) (*Shard[K, V], *readSnapshot[K, V]) {
+	for {
+		shard := m.shardFor(hash)
+		snap := shard.snap.Load()
+		if !shard.moved.Load() {
+			return shard, snap
+		}
+	}
+}
+
+// lookup returns the value of key in the snapshot, if it is live, and
+// whether the snapshot holds it expired.
+func (r *readSnapshot[K, V]) lookup(key K) (V, bool, bool) {
+	v, ok := r.items[key]
+	if ok && len(r.expires) > 0 {
+		if deadline, hasTTL := r.expires[key]; hasTTL && deadline <= nowNano() {
+			return *new(V), false, true
+		}
+	}
+	return v, ok, false
+}
@@ -0,0 +1,103 @@
+package cmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestReadMostly(t *testing.T) {
+	now := fakeClock(t)
+	m := NewWithOptions[string, int](WithReadMostly())
+	m.Set("a", 1)
+	m.MSet(map[string]int{"b": 2, "c": 3})
+	m.Upsert("a", 1, func(exist bool, old, v int) int { return old + v })
+	m.Remove("c")
+	m.SetWithTTL("d", 4, time.Second)
+	if v, ok := m.Get("a"); !ok || v != 2 {
+		t.Error("Get should see the latest write.", v, ok)
+	}
+	if !m.Has("b") || m.Has("c") || !m.Has("d") {
+		t.Error("Has should see the latest writes.")
+	}
+	*now += int64(time.Second)
+	if _, ok := m.Get("d"); ok || m.Has("d") {
+		t.Error("expired entries should not be read.")
+	}
+	m.Clear()
+	if m.Has("a") {
+		t.Error("Clear should be published.")
+	}
+}
+
+func TestReadMostlyLockFree(t *testing.T) {
+	m := NewWithOptions[string, int](WithReadMostly())
+	m.Set("a", 1)
+	m.WithShard("a", func(s *LockedShard[string, int]) {
+		done := make(chan int)
+		go func() {
+			v, _ := m.Get("a")
+			done <- v
+		}()
+		select {
+		case v := <-done:
+			if v != 1 {
+				t.Error(v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Get should not wait for the shard lock.")
+		}
+	})
+}
+
+func TestReadMostlyResize(t *testing.T) {
+	m := NewWithOptions[int, int](WithShardCount(2), WithReadMostly())
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	var (
+		stop   atomic.Bool
+		failed atomic.Int64
+		wg     sync.WaitGroup
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i = (i + 1) % 1000 {
+				if v, ok := m.Get(i); !ok || v != i {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+	for _, n := range []int{16, 5, 64} {
+		m.Resize(n)
+	}
+	stop.Store(true)
+	wg.Wait()
+	if failed.Load() != 0 {
+		t.Error("lock free reads missed entries during Resize.", failed.Load())
+	}
+}
+
+func TestReadMostlyCapacity(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("WithReadMostly with a capacity should panic.")
+		}
+	}()
+	NewWithOptions[string, int](WithReadMostly(), WithCapacity(10))
+}
+
+func TestShardLayout(t *testing.T) {
+	shards := NewMap[string, int]().table.Load().shards
+	size := unsafe.Sizeof(*shards[0])
+	for i := 1; i < len(shards); i++ {
+		if uintptr(unsafe.Pointer(shards[i]))-uintptr(unsafe.Pointer(shards[i-1])) != size {
+			t.Fatal("shards should be allocated contiguously.")
+		}
+	}
+}
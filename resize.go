@@ -0,0 +1,350 @@
+package cmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shardTable is a generation of shards. While Resize runs, next is the
+// table the entries move to, one shard at a time.
+type shardTable[K comparable, V any] struct {
+	shards []*Shard[K, V]
+	next   atomic.Pointer[shardTable[K, V]]
+}
+
+// shardSeq numbers shards in creation order, see Shard.seq.
+var shardSeq atomic.Uint64
+
+// newShards creates n empty shards configured like the shards of m.
+func (m *Map[K, V]) newShards(n int) []*Shard[K, V] {
+	// One contiguous, padded array rather than n separate allocations.
+	array := make([]Shard[K, V], n)
+	shards := make([]*Shard[K, V], n)
+	for i := range shards {
+		shard := &array[i]
+		shard.owner = m
+		shard.items = make(map[K]V)
+		shard.seq = shardSeq.Add(1)
+		shards[i] = shard
+	}
+	return shards
+}
+
+// shardFor returns the shard owning keys with the given hash, following
+// entries moved by Resize.
+func (m *Map[K, V]) shardFor(hash uint32) *Shard[K, V] {
+	t := m.table.Load()
+	shard := t.shards[uint(hash)%uint(len(t.shards))]
+	for shard.moved.Load() {
+		t = t.next.Load()
+		shard = t.shards[uint(hash)%uint(len(t.shards))]
+	}
+	return shard
+}
+
+// lockHash write locks and returns the shard owning keys with the given hash.
+// A shard only changes its moved flag under the write lock, so once it is
+// locked and not moved, it owns the key until unlocked.
+func (m *Map[K, V]) lockHash(hash uint32) *Shard[K, V] {
+	for t := m.table.Load(); ; t = t.next.Load() {
+		shard := t.shards[uint(hash)%uint(len(t.shards))]
+		shard.Lock()
+		if !shard.moved.Load() {
+			return shard
+		}
+		shard.Unlock()
+	}
+}
+
+// rlockHash is lockHash taking the read lock.
+func (m *Map[K, V]) rlockHash(hash uint32) *Shard[K, V] {
+	for t := m.table.Load(); ; t = t.next.Load() {
+		shard := t.shards[uint(hash)%uint(len(t.shards))]
+		shard.RLock()
+		if !shard.moved.Load() {
+			return shard
+		}
+		shard.RUnlock()
+	}
+}
+
+// lockKey write locks and returns the shard owning key.
+func (m *Map[K, V]) lockKey(key K) *Shard[K, V] {
+	return m.lockHash(m.sharding(key))
+}
+
+// rlockKey read locks and returns the shard owning key.
+func (m *Map[K, V]) rlockKey(key K) *Shard[K, V] {
+	return m.rlockHash(m.sharding(key))
+}
+
+// resizeGate keeps Resize from moving entries while operations spanning all
+// shards run. It lets those operations in while others are pinned, so they
+// may nest, and only holds them back while a shard is being moved. Resize
+// moves a shard once no operation is pinned, and the gate then passes
+// straight to the move, ahead of new pins. Operations that keep overlapping,
+// such as Count called in a loop from several goroutines, delay Resize until
+// they leave a gap.
+type resizeGate struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	pinned  int
+	moving  bool
+	waiting bool // Resize waits for pinned to drop to 0
+}
+
+// pinShards returns the shards holding the entries of the map, in locking
+// order, and keeps Resize from moving entries until unpinShards is called.
+func (m *Map[K, V]) pinShards() []*Shard[K, V] {
+	g := &m.gate
+	g.mu.Lock()
+	for g.moving {
+		g.cond.Wait()
+	}
+	g.pinned++
+	g.mu.Unlock()
+
+	t := m.table.Load()
+	next := t.next.Load()
+	if next == nil {
+		return t.shards
+	}
+	shards := make([]*Shard[K, V], 0, len(t.shards)+len(next.shards))
+	for _, shard := range t.shards {
+		if !shard.moved.Load() {
+			shards = append(shards, shard)
+		}
+	}
+	return append(shards, next.shards...)
+}
+
+// settled reports whether shards, returned by pinShards, are the shards of
+// the current table with no entry moved by Resize, so that keys with hash h
+// live in shards[h%len(shards)] until unpinned.
+func (m *Map[K, V]) settled(shards []*Shard[K, V]) bool {
+	t := m.table.Load()
+	return len(t.shards) == len(shards) && &t.shards[0] == &shards[0]
+}
+
+func (m *Map[K, V]) unpinShards() {
+	g := &m.gate
+	g.mu.Lock()
+	g.pinned--
+	if g.pinned == 0 {
+		g.moving = g.waiting
+		g.cond.Broadcast()
+	}
+	g.mu.Unlock()
+}
+
+// Resize changes the number of shards to n while the map stays in use. The
+// entries move one shard at a time: writers of the shard being moved wait
+// for the move, all other keys stay available, and every key stays visible
+// throughout. Before each move, Resize waits for the operations spanning all
+// shards in progress, such as Count, IterCb or a range over All, so it must
+// not be called from their callbacks or loop bodies. A shard locked by
+// someone else, as by a WithShard callback, is moved once it is released.
+//
+// Code that locks a shard returned by GetShard itself may find, once locked,
+// that the key moved to a shard of the new count. SetNoLock and RemoveNoLock
+// panic then until Resize returns, WithShard locks the shard owning the key
+// instead. The nested
+// map types of this package are never resized.
+func (m *Map[K, V]) Resize(n int) {
+	if n <= 0 {
+		panic("cmap: shard count must be positive")
+	}
+	m.resizing.Lock()
+	defer m.resizing.Unlock()
+	old := m.table.Load()
+	if n == len(old.shards) {
+		return
+	}
+	next := &shardTable[K, V]{shards: m.newShards(n)}
+	m.configureShards(next.shards)
+	old.next.Store(next)
+
+	g := &m.gate
+	for _, shard := range old.shards {
+		for {
+			g.mu.Lock()
+			if g.pinned > 0 {
+				g.waiting = true
+				for !g.moving {
+					g.cond.Wait()
+				}
+				g.waiting = false
+			}
+			g.moving = true
+			g.mu.Unlock()
+
+			moved := m.moveShard(shard, next)
+
+			g.mu.Lock()
+			g.moving = false
+			g.cond.Broadcast()
+			g.mu.Unlock()
+			if moved {
+				break
+			}
+			// The holder of a lock may be waiting for the gate, as a
+			// WithShard callback calling Count does: let it through.
+			time.Sleep(time.Millisecond)
+		}
+	}
+	m.table.Store(next)
+}
+
+// moveShard moves the entries of shard to the shards of next. Lookups that
+// find the shard moved retry in next. Waiting for a lock while the gate is
+// closed could deadlock, so it moves nothing and returns false if shard or
+// one of its destinations is locked, or if events of shard are still being
+// delivered, as those must reach the watchers before the events of the
+// shards taking over its keys.
+func (m *Map[K, V]) moveShard(shard *Shard[K, V], next *shardTable[K, V]) bool {
+	if !shard.tryLock() {
+		return false
+	}
+	defer shard.Unlock()
+	if !shard.delivery.idle() {
+		return false
+	}
+
+	n := uint(len(next.shards))
+	keys := make(map[*Shard[K, V]][]K)
+	for key := range shard.items {
+		dst := next.shards[uint(m.sharding(key))%n]
+		keys[dst] = append(keys[dst], key)
+	}
+	for key := range shard.calls {
+		if _, ok := shard.items[key]; !ok {
+			dst := next.shards[uint(m.sharding(key))%n]
+			keys[dst] = append(keys[dst], key)
+		}
+	}
+	locked := make([]*Shard[K, V], 0, len(keys))
+	for dst := range keys {
+		if !dst.tryLock() {
+			for _, dst := range locked {
+				dst.Unlock()
+			}
+			return false
+		}
+		locked = append(locked, dst)
+	}
+	for dst, keys := range keys {
+		dst.dirty = true
+		for _, key := range keys {
+			if val, ok := shard.items[key]; ok {
+				dst.items[key] = val
+				if dst.index != nil {
+					dst.index.insert(key)
+				}
+				if dst.policy != nil {
+					// The global budget already counts the entry.
+					dst.policy.add(key)
+				}
+			}
+			if deadline, ok := shard.expires[key]; ok {
+				if dst.expires == nil {
+					dst.expires = make(map[K]int64)
+				}
+				dst.expires[key] = deadline
+			}
+			if c, ok := shard.calls[key]; ok {
+				if dst.calls == nil {
+					dst.calls = make(map[K]*computeCall[V])
+				}
+				dst.calls[key] = c
+			}
+		}
+	}
+	// Publish the snapshots of the destinations before readers go there.
+	for _, dst := range locked {
+		dst.Unlock()
+	}
+	// Entries from several shards may now exceed a per shard capacity, the
+	// next insert into the shard evicts the excess.
+	shard.items = make(map[K]V)
+	shard.expires = nil
+	shard.calls = nil
+	shard.dirty = true
+	shard.moved.Store(true)
+	return true
+}
+
+// defaultMaxShards is the shard count WithAutoGrow stops at by default.
+const defaultMaxShards = 4096
+
+// WithAutoGrow doubles the shard count with Resize, in the background, when
+// the shards hold more than itemsPerShard entries on average. Growing stops
+// at the WithMaxShardCount cap, and for good once doubling did not shrink
+// the largest shard, as happens when the keys share few sharding hashes.
+// NewWithOptions panics if it is combined with WithPrefixSharding or
+// WithShardingFunc, which place keys by rules more shards may not spread.
+func WithAutoGrow(itemsPerShard int) Option {
+	return func(o *options) {
+		o.autoGrow = itemsPerShard
+	}
+}
+
+// checkAutoGrow panics if WithAutoGrow is combined with prefix or custom
+// sharding.
+func checkAutoGrow(o *options) {
+	if o.autoGrow > 0 && (o.prefixSegments != 0 || o.prefixSep != "" || o.sharding != nil) {
+		panic("cmap: WithAutoGrow can not be combined with WithPrefixSharding or WithShardingFunc")
+	}
+}
+
+// WithMaxShardCount caps the shard count WithAutoGrow grows to, 4096 by
+// default. It does not limit Resize.
+func WithMaxShardCount(n int) Option {
+	return func(o *options) {
+		o.maxShards = n
+	}
+}
+
+// maybeGrow starts a background grow check once the shard outgrew the
+// WithAutoGrow threshold. A shard whose check did not grow the map asks
+// again when it doubled in size, so skewed shards do not check on every
+// write. Write lock must be held.
+func (s *Shard[K, V]) maybeGrow() {
+	m := s.owner
+	if m.opts.autoGrow <= 0 || len(s.items) <= max(m.opts.autoGrow, s.growMark) || m.grown.Load() || !m.growing.CompareAndSwap(false, true) {
+		return
+	}
+	s.growMark = 2 * len(s.items)
+	go m.grow()
+}
+
+// grow doubles the shard count, up to the WithMaxShardCount cap, if the
+// shards hold more than the WithAutoGrow threshold on average. It stops
+// auto growing for good if the largest shard did not shrink.
+func (m *Map[K, V]) grow() {
+	defer m.growing.Store(false)
+	n, total, largest := m.shardLoad()
+	next := min(2*n, m.opts.maxShards)
+	if total/n <= m.opts.autoGrow || next <= n {
+		return
+	}
+	m.Resize(next)
+	if _, _, after := m.shardLoad(); after >= largest {
+		m.grown.Store(true)
+	}
+}
+
+// shardLoad returns the shard count, the number of entries and the size of
+// the largest shard, expired entries included.
+func (m *Map[K, V]) shardLoad() (n, total, largest int) {
+	shards := m.pinShards()
+	defer m.unpinShards()
+	for _, shard := range shards {
+		shard.RLock()
+		size := len(shard.items)
+		shard.RUnlock()
+		total += size
+		largest = max(largest, size)
+	}
+	return len(shards), total, largest
+}
@@ -0,0 +1,309 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResize(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(4))
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	for _, n := range []int{64, 3, 4} {
+		m.Resize(n)
+		if m.ShardCount() != n {
+			t.Fatal("Resize should change the shard count.", m.ShardCount())
+		}
+		if m.Count() != 1000 {
+			t.Fatal("Resize should keep every entry.", m.Count())
+		}
+		for i := 0; i < 1000; i++ {
+			if v, ok := m.Get(strconv.Itoa(i)); !ok || v != i {
+				t.Fatal("entry lost by Resize", i)
+			}
+		}
+	}
+}
+
+func TestResizeKeepsTTL(t *testing.T) {
+	now := fakeClock(t)
+	m := NewWithOptions[string, int](WithShardCount(2))
+	m.SetWithTTL("session", 1, time.Minute)
+	m.Resize(16)
+	*now += int64(time.Minute)
+	if m.Has("session") {
+		t.Error("Resize should keep TTLs.")
+	}
+}
+
+func TestResizeCapacity(t *testing.T) {
+	m := NewWithOptions[int, int](WithShardCount(2), WithCapacity(100))
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	m.Resize(8)
+	for i := 100; i < 200; i++ {
+		m.Set(i, i)
+	}
+	if m.Count() != 100 {
+		t.Error("the capacity should hold after Resize.", m.Count())
+	}
+}
+
+func TestResizeWhileInUse(t *testing.T) {
+	m := NewWithOptions[int, int](WithShardCount(2))
+	const preset = 5000
+	for i := 0; i < preset; i++ {
+		m.Set(i, i)
+	}
+	var (
+		stop   atomic.Bool
+		wg     sync.WaitGroup
+		failed atomic.Int64
+		next   atomic.Int64
+	)
+	next.Store(preset)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				i := int(next.Add(1))
+				m.Set(i, i)
+				if v, ok := m.Get(i); !ok || v != i {
+					failed.Add(1)
+				}
+				m.Upsert(i%preset, 0, func(exist bool, old, v int) int {
+					if !exist {
+						failed.Add(1)
+					}
+					return old
+				})
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for !stop.Load() {
+			if m.Count() < preset {
+				failed.Add(1)
+			}
+			n := 0
+			for range m.All() {
+				n++
+			}
+			if n < preset {
+				failed.Add(1)
+			}
+		}
+	}()
+	for _, n := range []int{32, 7, 128} {
+		m.Resize(n)
+	}
+	stop.Store(true)
+	wg.Wait()
+	if failed.Load() != 0 {
+		t.Error("entries were missing during Resize", failed.Load())
+	}
+	if m.Count() != int(next.Load()) {
+		t.Error("writes were lost during Resize", m.Count(), next.Load())
+	}
+}
+
+func TestResizeAtomically(t *testing.T) {
+	m := NewWithOptions[int, int](WithShardCount(4))
+	keys := []int{1, 2, 3, 4, 5}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			m.Atomically(keys, func(tx Tx[int, int]) error {
+				for _, k := range keys {
+					v, _ := tx.Get(k)
+					tx.Set(k, v+1)
+				}
+				return nil
+			})
+		}
+		close(done)
+	}()
+	m.Resize(16)
+	m.Resize(3)
+	<-done
+	for _, k := range keys {
+		if v, _ := m.Get(k); v != 100 {
+			t.Error("transactions should not be lost by Resize", k, v)
+		}
+	}
+}
+
+func TestResizeLockedShard(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(2))
+	other := "b"
+	for m.GetShard(other) == m.GetShard("a") {
+		other += "b"
+	}
+	resized := make(chan struct{})
+	done := make(chan struct{})
+	go m.WithShard("a", func(s *LockedShard[string, int]) {
+		go func() {
+			m.Resize(4)
+			close(resized)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		// Resize must not close the gate while it waits for this shard.
+		m.Atomically([]string{other}, func(tx Tx[string, int]) error {
+			tx.Set(other, 1)
+			return nil
+		})
+		s.Set("a", 1)
+		close(done)
+	})
+	for _, c := range []chan struct{}{done, resized} {
+		select {
+		case <-c:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Resize deadlocked with a WithShard callback.")
+		}
+	}
+	if m.ShardCount() != 4 || !m.Has("a") || !m.Has(other) {
+		t.Error("Resize should move the locked shard once released.", m.ShardCount(), m.Items())
+	}
+}
+
+func TestAutoGrow(t *testing.T) {
+	m := NewWithOptions[int, int](WithShardCount(1), WithAutoGrow(100))
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	deadline := time.Now().Add(time.Second)
+	for m.growing.Load() || m.ShardCount() == 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("WithAutoGrow should resize the map.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if m.Count() != 1000 {
+		t.Error("growing should keep every entry.", m.Count())
+	}
+}
+
+// waitGrowing waits for a background grow check to finish.
+func waitGrowing[K comparable, V any](t *testing.T, m *Map[K, V]) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for m.growing.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("the grow check should finish.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAutoGrowLimits(t *testing.T) {
+	// A single crowded shard does not grow a map that is lightly loaded on average.
+	skewed := NewWithOptions[string, int](WithShardCount(4), WithAutoGrow(10),
+		WithHasher(HasherFunc(func(key []byte) uint32 {
+			if key[0] == 'a' {
+				return 0
+			}
+			return uint32(len(key))
+		})))
+	for i := 0; i < 20; i++ {
+		skewed.Set("a"+strconv.Itoa(i), i)
+		waitGrowing(t, skewed)
+	}
+	if skewed.ShardCount() != 4 {
+		t.Error("a crowded shard alone should not grow the map.", skewed.ShardCount())
+	}
+
+	// Growing stops at the cap.
+	capped := NewWithOptions[int, int](WithShardCount(1), WithAutoGrow(10), WithMaxShardCount(4))
+	for i := 0; i < 1000; i++ {
+		capped.Set(i, i)
+		waitGrowing(t, capped)
+	}
+	if capped.ShardCount() != 4 {
+		t.Error("growing should stop at WithMaxShardCount.", capped.ShardCount())
+	}
+
+	// Growing stops for good when doubling does not spread the keys.
+	same := NewWithOptions[string, int](WithShardCount(1), WithAutoGrow(10),
+		WithHasher(HasherFunc(func([]byte) uint32 { return 0 })))
+	for i := 0; i < 1000; i++ {
+		same.Set(strconv.Itoa(i), i)
+		waitGrowing(t, same)
+	}
+	if same.ShardCount() != 2 || !same.grown.Load() {
+		t.Error("growing should stop once it does not shrink the largest shard.", same.ShardCount())
+	}
+}
+
+func TestNoLockDuringResize(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(2))
+	old := m.table.Load().shards
+	key := "a"
+	for m.GetShard(key) != old[0] {
+		key += "a"
+	}
+	m.Set(key, 1)
+
+	// Resize moves old[0], then waits for old[1].
+	old[1].Lock()
+	done := make(chan struct{})
+	go func() {
+		m.Resize(8)
+		close(done)
+	}()
+	for !old[0].moved.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	old[0].Lock()
+	for name, fn := range map[string]func(){
+		"SetNoLock":    func() { m.SetNoLock(key, 2) },
+		"RemoveNoLock": func() { m.RemoveNoLock(key) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error(name, "should panic when the locked shard was moved.")
+				}
+			}()
+			fn()
+		}()
+	}
+	old[0].Unlock()
+	old[1].Unlock()
+	<-done
+	if v, _ := m.Get(key); v != 1 {
+		t.Error("the moved entry should be kept.", v)
+	}
+}
+
+func TestResizeWatch(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(2))
+	w := m.Watch("a")
+	defer w.Close()
+	m.Set("a", 1)
+	m.Resize(8)
+	m.Set("a", 2)
+	if e := nextEvent(t, w); e.New != 1 {
+		t.Error(e)
+	}
+	if e := nextEvent(t, w); e.New != 2 || e.Old != 1 {
+		t.Error("watchers should follow keys moved by Resize.", e)
+	}
+}
+
+func TestInvalidResize(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Resize(0) should panic.")
+		}
+	}()
+	NewMap[string, int]().Resize(0)
+}
@@ -0,0 +1,87 @@
+package cmap
+
+import "encoding/json"
+
+// Snapshot is an immutable copy of a map taken at a single point in time.
+type Snapshot[K comparable, V any] struct {
+	shards   []map[K]V
+	sharding func(key K) uint32
+	count    int
+}
+
+// Snapshot read locks every shard, in shard order, copies their content and
+// releases them. Unlike Items or IterCb, the copy is consistent across shards:
+// no write is visible in one shard and missing in another.
+func (m *Map[K, V]) Snapshot() *Snapshot[K, V] {
+	shards := m.pinShards()
+	defer m.unpinShards()
+	for _, shard := range shards {
+		shard.RLock()
+	}
+	snap := &Snapshot[K, V]{
+		shards:   make([]map[K]V, len(shards)),
+		sharding: m.sharding,
+	}
+	// While Resize runs, shards mixes both tables, so entries are rehashed
+	// for Get to find them.
+	rehash := m.table.Load().next.Load() != nil
+	if rehash {
+		for i := range snap.shards {
+			snap.shards[i] = make(map[K]V)
+		}
+	}
+	now := nowNano()
+	for i, shard := range shards {
+		items := snap.shards[i]
+		if !rehash {
+			items = make(map[K]V, len(shard.items))
+			snap.shards[i] = items
+		}
+		for key, val := range shard.items {
+			if shard.expiredAt(key, now) {
+				continue
+			}
+			if rehash {
+				items = snap.shards[uint(m.sharding(key))%uint(len(shards))]
+			}
+			items[key] = val
+			snap.count++
+		}
+	}
+	for _, shard := range shards {
+		shard.RUnlock()
+	}
+	return snap
+}
+
+// Get retrieves an element of the snapshot under given key.
+func (s *Snapshot[K, V]) Get(key K) (V, bool) {
+	v, ok := s.shards[uint(s.sharding(key))%uint(len(s.shards))][key]
+	return v, ok
+}
+
+// Len returns the number of elements in the snapshot.
+func (s *Snapshot[K, V]) Len() int {
+	return s.count
+}
+
+// Range calls fn for every element of the snapshot until fn returns false.
+func (s *Snapshot[K, V]) Range(fn func(key K, v V) bool) {
+	for _, items := range s.shards {
+		for key, val := range items {
+			if !fn(key, val) {
+				return
+			}
+		}
+	}
+}
+
+// MarshalJSON encodes the snapshot as a json object.
+func (s *Snapshot[K, V]) MarshalJSON() ([]byte, error) {
+	tmp := make(map[K]V, s.count)
+	s.Range(func(key K, v V) bool {
+		tmp[key] = v
+		return true
+	})
+	return json.Marshal(tmp)
+}
@@ -0,0 +1,95 @@
+package cmap
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	m := NewMap[string, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	snap := m.Snapshot()
+	m.Set("100", 100)
+	m.Remove("0")
+
+	if snap.Len() != 100 {
+		t.Error("Expecting 100 elements in the snapshot, got", snap.Len())
+	}
+	if v, ok := snap.Get("0"); !ok || v != 0 {
+		t.Error("the snapshot should not see later removals.")
+	}
+	if _, ok := snap.Get("100"); ok {
+		t.Error("the snapshot should not see later writes.")
+	}
+	count := 0
+	snap.Range(func(key string, v int) bool {
+		count++
+		return count < 10
+	})
+	if count != 10 {
+		t.Error("Range should stop when fn returns false.")
+	}
+}
+
+func TestSnapshotSkipsExpired(t *testing.T) {
+	now := fakeClock(t)
+	m := NewMap[string, int]()
+	m.SetWithTTL("a", 1, time.Second)
+	m.Set("b", 2)
+	*now += int64(time.Second)
+
+	snap := m.Snapshot()
+	j, err := json.Marshal(snap)
+	if err != nil || string(j) != `{"b":2}` {
+		t.Error("the snapshot should leave expired entries out.", string(j))
+	}
+}
+
+func TestSnapshotConsistent(t *testing.T) {
+	m := NewMap[string, int]()
+	const accounts = 50
+	for i := 0; i < accounts; i++ {
+		m.Set(strconv.Itoa(i), 100)
+	}
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				from, to := strconv.Itoa((g+i)%accounts), strconv.Itoa((g+i*7+1)%accounts)
+				m.Atomically([]string{from, to}, func(tx Tx[string, int]) error {
+					a, _ := tx.Get(from)
+					b, _ := tx.Get(to)
+					tx.Set(from, a-1)
+					tx.Set(to, b+1)
+					return nil
+				})
+			}
+		}(g)
+	}
+	for i := 0; i < 100; i++ {
+		total := 0
+		m.Snapshot().Range(func(key string, v int) bool {
+			total += v
+			return true
+		})
+		if total != accounts*100 {
+			t.Error("the snapshot total should match, got", total)
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
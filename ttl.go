@@ -0,0 +1,238 @@
+package cmap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// nowNano is the clock used for TTLs, in unix nanoseconds.
+var nowNano = func() int64 {
+	return time.Now().UnixNano()
+}
+
+// WithOnExpire registers fn to be called for every entry removed because its
+// TTL passed. fn runs after the shard lock has been released.
+// NewWithOptions panics if K and V do not match the map.
+func WithOnExpire[K comparable, V any](fn func(key K, v V)) Option {
+	return func(o *options) {
+		o.onExpire = fn
+	}
+}
+
+// WithJanitor starts a goroutine removing expired entries every interval.
+// It sweeps one shard at a time, so it never holds more than one shard lock.
+// Call Close to stop it.
+func WithJanitor(interval time.Duration) Option {
+	return func(o *options) {
+		o.janitorInterval = interval
+	}
+}
+
+// ttlFromOptions applies the TTL related options to a new map.
+func (m *Map[K, V]) ttlFromOptions(o *options) {
+	if o.onExpire != nil {
+		fn, ok := o.onExpire.(func(key K, v V))
+		if !ok {
+			panic(fmt.Sprintf("cmap: OnExpire callback %T does not match map types", o.onExpire))
+		}
+		m.onExpire = fn
+	}
+	if o.janitorInterval > 0 {
+		m.startJanitor(o.janitorInterval)
+	}
+}
+
+// startJanitor starts the WithJanitor goroutine, stopped by Close.
+func (m *Map[K, V]) startJanitor(interval time.Duration) {
+	m.stopJanitor = make(chan struct{})
+	m.janitorDone = make(chan struct{})
+	go m.janitor(interval)
+}
+
+// SetWithTTL sets the given value under the specified key. The entry is
+// treated as missing once ttl has passed. It is removed, and OnExpire
+// called, by the first Get, Has or write of the key that finds it expired,
+// by the janitor or by DeleteExpired. Until then it keeps its memory and
+// its place in the capacity of the map.
+func (m *Map[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	shard := m.lockKey(key)
+	shard.store(key, value, OpSet)
+	shard.setDeadline(key, nowNano()+int64(ttl))
+	m.unlock(shard)
+}
+
+// setDeadline sets the TTL deadline of key, unless the eviction policy
+// rejected it. Write lock must be held.
+func (s *Shard[K, V]) setDeadline(key K, deadline int64) {
+	if _, ok := s.items[key]; !ok {
+		return
+	}
+	if w := s.owner.wal; w != nil {
+		if err := w.logTTL(key, deadline); err != nil {
+			s.walErr = err
+			return
+		}
+	}
+	if s.expires == nil {
+		s.expires = make(map[K]int64)
+	}
+	s.expires[key] = deadline
+	s.dirty = true
+}
+
+// DeleteExpired removes every expired entry, one shard at a time,
+// and returns how many were removed.
+func (m *Map[K, V]) DeleteExpired() int {
+	removed := 0
+	for _, shard := range m.pinShards() {
+		shard.Lock()
+		removed += shard.purgeAllExpired()
+		m.unlock(shard)
+	}
+	m.unpinShards()
+	return removed
+}
+
+// Close stops the janitor started by WithJanitor and waits for it to exit,
+// then syncs and closes the write-ahead log opened by OpenWAL. The map stays
+// usable, but writes are no longer logged.
+func (m *Map[K, V]) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		if m.stopJanitor != nil {
+			close(m.stopJanitor)
+			<-m.janitorDone
+		}
+		if m.wal != nil {
+			err = m.closeWAL()
+		}
+	})
+	return err
+}
+
+func (m *Map[K, V]) janitor(interval time.Duration) {
+	defer close(m.janitorDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !m.sweep() {
+				return
+			}
+		case <-m.stopJanitor:
+			return
+		}
+	}
+}
+
+// sweep runs DeleteExpired for the janitor. It reports false once the
+// write-ahead log failed, which WALError then returns.
+func (m *Map[K, V]) sweep() (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, isErr := r.(error); !isErr || !errors.Is(err, ErrWAL) {
+				panic(r)
+			}
+		}
+	}()
+	m.DeleteExpired()
+	return true
+}
+
+// now returns the current time if any key of the shard has a TTL, 0 otherwise.
+// Lock must be held.
+func (s *Shard[K, V]) now() int64 {
+	if len(s.expires) == 0 {
+		return 0
+	}
+	return nowNano()
+}
+
+// expiredAt reports whether key has a TTL that passed at now. Lock must be held.
+func (s *Shard[K, V]) expiredAt(key K, now int64) bool {
+	if len(s.expires) == 0 {
+		return false
+	}
+	deadline, ok := s.expires[key]
+	return ok && deadline <= now
+}
+
+// isExpired reports whether key has a TTL that already passed. Lock must be held.
+func (s *Shard[K, V]) isExpired(key K) bool {
+	return s.expiredAt(key, s.now())
+}
+
+// count returns the number of live entries. Lock must be held.
+func (s *Shard[K, V]) count() int {
+	n := len(s.items)
+	if now := s.now(); now != 0 {
+		for _, deadline := range s.expires {
+			if deadline <= now {
+				n--
+			}
+		}
+	}
+	return n
+}
+
+// clearTTL forgets the TTL of key. Write lock must be held.
+func (s *Shard[K, V]) clearTTL(key K) {
+	if _, ok := s.expires[key]; ok {
+		if w := s.owner.wal; w != nil {
+			if err := w.logTTL(key, 0); err != nil {
+				s.walErr = err
+				return
+			}
+		}
+		s.clearTTLLogged(key)
+	}
+}
+
+// clearTTLLogged is clearTTL for a change the log already holds, see logTx.
+// Write lock must be held.
+func (s *Shard[K, V]) clearTTLLogged(key K) {
+	if _, ok := s.expires[key]; ok {
+		delete(s.expires, key)
+		s.dirty = true
+	}
+}
+
+// expireKey removes key if its TTL passed, for reads that found it expired
+// under the read lock.
+func (m *Map[K, V]) expireKey(key K) {
+	shard := m.lockKey(key)
+	shard.purgeExpired(key)
+	m.unlock(shard)
+}
+
+// purgeExpired removes key if its TTL passed, queueing the OnExpire callback.
+// Write lock must be held.
+func (s *Shard[K, V]) purgeExpired(key K) {
+	if s.isExpired(key) {
+		s.expire(key)
+	}
+}
+
+// purgeAllExpired removes every expired entry and returns how many were
+// removed. Write lock must be held.
+func (s *Shard[K, V]) purgeAllExpired() int {
+	now := s.now()
+	removed := 0
+	for key, deadline := range s.expires {
+		if deadline <= now {
+			s.expire(key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// expire drops key and queues the OnExpire callback. Write lock must be held.
+func (s *Shard[K, V]) expire(key K) {
+	v, _ := s.drop(key, OpExpire)
+	if s.owner.onExpire != nil {
+		s.pending = append(s.pending, notification[K, V]{key: key, val: v, expired: true})
+	}
+}
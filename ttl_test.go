@@ -0,0 +1,219 @@
+package cmap
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock replaces nowNano for the duration of a test.
+func fakeClock(t *testing.T) *int64 {
+	now := time.Now().UnixNano()
+	old := nowNano
+	nowNano = func() int64 { return now }
+	t.Cleanup(func() { nowNano = old })
+	return &now
+}
+
+func TestSetWithTTL(t *testing.T) {
+	now := fakeClock(t)
+	m := NewMap[string, int]()
+	m.SetWithTTL("session", 1, time.Minute)
+	m.Set("config", 2)
+
+	if v, ok := m.Get("session"); !ok || v != 1 {
+		t.Error("session should be alive before its TTL.")
+	}
+
+	*now += int64(time.Minute)
+
+	if _, ok := m.Get("session"); ok {
+		t.Error("Get should not return expired entries.")
+	}
+	if m.Has("session") {
+		t.Error("Has should not report expired entries.")
+	}
+	if _, ok := m.GetBytes([]byte("session")); ok {
+		t.Error("GetBytes should not return expired entries.")
+	}
+	if m.Count() != 1 {
+		t.Error("Count should leave expired entries out.")
+	}
+	if keys := m.Keys(); len(keys) != 1 || keys[0] != "config" {
+		t.Error("Keys should leave expired entries out.", keys)
+	}
+	if items := m.Items(); len(items) != 1 {
+		t.Error("Items should leave expired entries out.")
+	}
+	j, err := json.Marshal(m)
+	if err != nil || string(j) != `{"config":2}` {
+		t.Error("MarshalJSON should leave expired entries out.", string(j))
+	}
+}
+
+func TestSetClearsTTL(t *testing.T) {
+	now := fakeClock(t)
+	m := NewMap[string, int]()
+	m.SetWithTTL("key", 1, time.Second)
+	m.Set("key", 2)
+	*now += int64(time.Hour)
+
+	if v, ok := m.Get("key"); !ok || v != 2 {
+		t.Error("Set should drop the TTL of the key.")
+	}
+}
+
+func TestExpiredWrites(t *testing.T) {
+	now := fakeClock(t)
+	var (
+		mu      sync.Mutex
+		expired []string
+	)
+	m := NewWithOptions[string, int](WithOnExpire(func(key string, v int) {
+		mu.Lock()
+		expired = append(expired, key+"="+strconv.Itoa(v))
+		mu.Unlock()
+	}))
+	m.SetWithTTL("a", 1, time.Second)
+	m.SetWithTTL("b", 2, time.Second)
+	m.SetWithTTL("c", 3, time.Second)
+	*now += int64(time.Second)
+
+	if !m.SetIfAbsent("a", 10) {
+		t.Error("SetIfAbsent should replace an expired entry.")
+	}
+	m.Upsert("b", 20, func(exist bool, valueInMap, newValue int) int {
+		if exist {
+			t.Error("Upsert should not see an expired entry.")
+		}
+		return newValue
+	})
+	if _, ok := m.Pop("c"); ok {
+		t.Error("Pop should not return an expired entry.")
+	}
+	if len(expired) != 3 {
+		t.Error("OnExpire should fire for every expired entry found by a write.", expired)
+	}
+}
+
+func TestExpiredOverwrites(t *testing.T) {
+	now := fakeClock(t)
+	expired := 0
+	m := NewWithOptions[string, int](WithShardCount(1), WithMetrics(),
+		WithOnExpire(func(key string, v int) { expired++ }))
+	w := m.Watch("a")
+	defer w.Close()
+	writes := map[string]func(){
+		"Set":        func() { m.Set("a", 2) },
+		"SetWithTTL": func() { m.SetWithTTL("a", 2, time.Minute) },
+		"MSet":       func() { m.MSet(map[string]int{"a": 2}) },
+		"SetBytes":   func() { m.SetBytes([]byte("a"), 2) },
+		"Swap":       func() { m.Swap("a", 2) },
+		"Remove":     func() { m.Remove("a") },
+	}
+	for name, write := range writes {
+		m.SetWithTTL("a", 1, time.Second)
+		nextEvent(t, w)
+		*now += int64(time.Second)
+		expired = 0
+		write()
+		if expired != 1 {
+			t.Error(name, "should expire the entry it overwrites.", expired)
+		}
+		if e := nextEvent(t, w); e.Op != OpExpire {
+			t.Error(name, "should report the expiry first.", e)
+		}
+		if name != "Remove" {
+			if e := nextEvent(t, w); e.Existed {
+				t.Error(name, "should not see the expired value.", e)
+			}
+		}
+	}
+	if st := m.Stats(); st.Total.Removes != 0 {
+		t.Error("removing an expired entry should not count as a remove.", st.Total.Removes)
+	}
+}
+
+func TestExpiredReads(t *testing.T) {
+	for _, readMostly := range []bool{false, true} {
+		now := fakeClock(t)
+		expired := 0
+		opts := []Option{WithShardCount(1), WithOnExpire(func(key string, v int) { expired++ })}
+		if readMostly {
+			opts = append(opts, WithReadMostly())
+		}
+		m := NewWithOptions[string, int](opts...)
+		m.SetWithTTL("a", 1, time.Second)
+		m.SetWithTTL("b", 2, time.Second)
+		m.SetWithTTL("c", 3, time.Second)
+		*now += int64(time.Second)
+
+		m.Get("a")
+		m.Has("b")
+		m.GetBytes([]byte("c"))
+		if expired != 3 {
+			t.Error("OnExpire should fire for every expired entry found by a read.", readMostly, expired)
+		}
+		if n := len(m.GetShard("a").items); n != 0 {
+			t.Error("reads should remove the expired entries they find.", readMostly, n)
+		}
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	now := fakeClock(t)
+	expired := 0
+	m := NewWithOptions[string, int](WithOnExpire(func(key string, v int) {
+		expired++
+	}))
+	for i := 0; i < 100; i++ {
+		m.SetWithTTL(strconv.Itoa(i), i, time.Duration(i+1)*time.Second)
+	}
+	*now += int64(50 * time.Second)
+
+	if n := m.DeleteExpired(); n != 50 {
+		t.Error("Expecting 50 expired entries, got", n)
+	}
+	if expired != 50 {
+		t.Error("OnExpire should fire for every removed entry.")
+	}
+	if m.Count() != 50 {
+		t.Error("Expecting 50 elements left.")
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	done := make(chan string, 1)
+	m := NewWithOptions[string, int](
+		WithJanitor(time.Millisecond),
+		WithOnExpire(func(key string, v int) { done <- key }),
+	)
+	defer m.Close()
+	m.SetWithTTL("session", 1, time.Millisecond)
+
+	select {
+	case key := <-done:
+		if key != "session" {
+			t.Error("unexpected expired key", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("janitor did not remove the expired entry")
+	}
+	m.GetShard("session").RLock()
+	_, ok := m.GetShard("session").items["session"]
+	m.GetShard("session").RUnlock()
+	if ok {
+		t.Error("janitor should remove the entry from the shard.")
+	}
+}
+
+func TestOnExpireTypeMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("a mismatching OnExpire callback should panic.")
+		}
+	}()
+	NewWithOptions[string, int](WithOnExpire(func(key string, v string) {}))
+}
@@ -0,0 +1,163 @@
+package cmap
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Tx gives an Atomically callback access to the keys it locked.
+// Using any other key panics.
+type Tx[K comparable, V any] interface {
+	// Get returns the value of key, including writes made earlier in the transaction.
+	Get(key K) (V, bool)
+	// Set sets key to value when the transaction commits.
+	Set(key K, value V)
+	// Delete removes key when the transaction commits.
+	Delete(key K)
+}
+
+// txWrite is a buffered write of a transaction.
+type txWrite[V any] struct {
+	val     V
+	deleted bool
+}
+
+type mapTx[K comparable, V any] struct {
+	m      *Map[K, V]
+	keys   map[K]struct{}
+	writes map[K]txWrite[V]
+}
+
+func (tx *mapTx[K, V]) check(key K) {
+	if _, ok := tx.keys[key]; !ok {
+		panic(fmt.Sprintf("cmap: key %v is not part of the transaction", key))
+	}
+}
+
+func (tx *mapTx[K, V]) Get(key K) (V, bool) {
+	tx.check(key)
+	if w, ok := tx.writes[key]; ok {
+		return w.val, !w.deleted
+	}
+	v, ok := tx.m.GetShard(key).items[key]
+	return v, ok
+}
+
+func (tx *mapTx[K, V]) Set(key K, value V) {
+	tx.check(key)
+	tx.writes[key] = txWrite[V]{val: value}
+}
+
+func (tx *mapTx[K, V]) Delete(key K) {
+	tx.check(key)
+	tx.writes[key] = txWrite[V]{deleted: true}
+}
+
+// Atomically locks the shards holding keys, in ascending shard order so
+// concurrent transactions can not deadlock, and runs fn with a Tx limited to
+// those keys. Writes are buffered and applied together when fn returns nil.
+// If fn returns an error or panics, none of its writes are applied.
+// fn must not call other methods of the map for keys in locked shards.
+func (m *Map[K, V]) Atomically(keys []K, fn func(tx Tx[K, V]) error) error {
+	tx := &mapTx[K, V]{
+		m:      m,
+		keys:   make(map[K]struct{}, len(keys)),
+		writes: make(map[K]txWrite[V]),
+	}
+	// No entry moves while pinned, so the shards found stay the owners.
+	m.pinShards()
+	defer m.unpinShards()
+	var shards []*Shard[K, V]
+	seen := make(map[*Shard[K, V]]bool)
+	for _, key := range keys {
+		tx.keys[key] = struct{}{}
+		if shard := m.GetShard(key); !seen[shard] {
+			seen[shard] = true
+			shards = append(shards, shard)
+		}
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].seq < shards[j].seq })
+	for _, shard := range shards {
+		shard.Lock()
+	}
+	defer func() {
+		// Evicting from other shards for the budget locks them, and a log
+		// failure panics, so both wait until all of these are released.
+		overBudget := false
+		var walErr error
+		for i := len(shards) - 1; i >= 0; i-- {
+			overBudget = overBudget || shards[i].overBudget
+			if walErr == nil {
+				walErr = shards[i].walErr
+			}
+			shards[i].overBudget, shards[i].walErr = false, nil
+			m.unlock(shards[i])
+		}
+		if overBudget {
+			m.evictOtherShards()
+		}
+		if walErr != nil {
+			panicWAL(walErr)
+		}
+	}()
+
+	for key := range tx.keys {
+		m.GetShard(key).purgeExpired(key)
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := m.logTx(tx.writes); err != nil {
+		shards[0].walErr = err
+		return nil
+	}
+	for key, w := range tx.writes {
+		shard := m.GetShard(key)
+		if w.deleted {
+			shard.dropLogged(key, OpRemove)
+		} else {
+			shard.storeLogged(key, w.val, OpSet)
+			shard.clearTTLLogged(key)
+		}
+	}
+	return nil
+}
+
+// logTx expires the entries under the writes of a transaction that expired
+// in the meantime, then writes the writes to the log as one batch before any
+// is applied, so a failure or crash keeps all or none of them. Shard locks of
+// the keys must be held.
+func (m *Map[K, V]) logTx(writes map[K]txWrite[V]) error {
+	for key := range writes {
+		shard := m.GetShard(key)
+		if shard.purgeExpired(key); shard.walErr != nil {
+			return shard.walErr
+		}
+	}
+	w := m.wal
+	if w == nil {
+		return nil
+	}
+	var records []byte
+	for key, tw := range writes {
+		shard := m.GetShard(key)
+		var err error
+		if tw.deleted {
+			if _, ok := shard.items[key]; ok {
+				records, err = w.appendDelete(records, key)
+			}
+		} else {
+			records, err = w.appendSet(records, key, tw.val)
+			if _, ok := shard.expires[key]; ok && err == nil {
+				records, err = w.appendTTL(records, key, 0)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return w.logBatch(records)
+}
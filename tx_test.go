@@ -0,0 +1,123 @@
+package cmap
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestAtomicallyTransfer(t *testing.T) {
+	m := NewMap[string, int]()
+	m.Set("alice", 100)
+	m.Set("bob", 0)
+
+	err := m.Atomically([]string{"alice", "bob"}, func(tx Tx[string, int]) error {
+		a, _ := tx.Get("alice")
+		b, _ := tx.Get("bob")
+		tx.Set("alice", a-30)
+		tx.Set("bob", b+30)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if a, _ := m.Get("alice"); a != 70 {
+		t.Error("Expecting alice to have 70, got", a)
+	}
+	if b, _ := m.Get("bob"); b != 30 {
+		t.Error("Expecting bob to have 30, got", b)
+	}
+}
+
+func TestAtomicallyRollback(t *testing.T) {
+	m := NewMap[string, int]()
+	m.Set("a", 1)
+	m.Set("b", 2)
+	boom := errors.New("boom")
+
+	err := m.Atomically([]string{"a", "b", "c"}, func(tx Tx[string, int]) error {
+		tx.Set("a", 10)
+		tx.Delete("b")
+		tx.Set("c", 3)
+		if v, ok := tx.Get("a"); !ok || v != 10 {
+			t.Error("Tx.Get should see earlier writes.")
+		}
+		if _, ok := tx.Get("b"); ok {
+			t.Error("Tx.Get should see earlier deletes.")
+		}
+		return boom
+	})
+	if err != boom {
+		t.Error("Atomically should return the callback error.")
+	}
+	if v, _ := m.Get("a"); v != 1 {
+		t.Error("a should be rolled back.")
+	}
+	if !m.Has("b") || m.Has("c") {
+		t.Error("b and c should be rolled back.")
+	}
+}
+
+func TestAtomicallyUnknownKey(t *testing.T) {
+	m := NewMap[string, int]()
+	defer func() {
+		if recover() == nil {
+			t.Error("keys outside the transaction should panic.")
+		}
+		// The shards must have been unlocked.
+		m.Set("a", 1)
+	}()
+	m.Atomically([]string{"a"}, func(tx Tx[string, int]) error {
+		tx.Set("b", 1)
+		return nil
+	})
+}
+
+func TestAtomicallyConcurrent(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(4))
+	const accounts = 20
+	for i := 0; i < accounts; i++ {
+		m.Set(strconv.Itoa(i), 100)
+	}
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				from, to := strconv.Itoa((g+i)%accounts), strconv.Itoa((g*7+i*3+1)%accounts)
+				m.Atomically([]string{from, to}, func(tx Tx[string, int]) error {
+					a, _ := tx.Get(from)
+					tx.Set(from, a-1)
+					b, _ := tx.Get(to)
+					tx.Set(to, b+1)
+					return nil
+				})
+				// Single key writers must be serialized with transactions.
+				m.Upsert(from, 0, func(exist bool, v, n int) int { return v })
+			}
+		}(g)
+	}
+	wg.Wait()
+	total := 0
+	m.IterCb(func(key string, v int) { total += v })
+	if total != accounts*100 {
+		t.Error("transfers should preserve the total, got", total)
+	}
+}
+
+func TestUint64MapMultiKeys(t *testing.T) {
+	m := NewUint64Map()
+	res := m.InsertOrIncrementMultiKeys([]string{"a", "b", "a"})
+	if len(res) != 3 || res[0] != 1 || res[1] != 1 || res[2] != 2 {
+		t.Error("unexpected counts", res)
+	}
+	deleted := m.DecrementOrDeleteMultiKeys([]string{"a", "b"})
+	if len(deleted) != 1 || deleted[0] != "b" {
+		t.Error("b should be deleted, got", deleted)
+	}
+	if v, _ := m._cmap.Get("a"); v.(uint64) != 1 {
+		t.Error("a should be decremented to 1.")
+	}
+}
@@ -8,7 +8,7 @@ import (
 // Uint64Map uses atomic uint64 as value for key
 // CMap(key string, value uint64)
 type Uint64Map struct {
-	_cmap ConcurrentMap
+	_cmap *Map[string, interface{}]
 	mtx   *sync.RWMutex
 }
 
@@ -51,7 +51,7 @@ func (m *Uint64Map) Pop(key string) (interface{}, bool) {
 func NewUint64Map() *Uint64Map {
 	m := new(Uint64Map)
 	m.mtx = new(sync.RWMutex)
-	m._cmap = New()
+	m._cmap = NewMap[string, interface{}]()
 	return m
 }
 
@@ -75,8 +75,12 @@ func (m *Uint64Map) InsertOrIncrementKey(key string) uint64 {
 }
 
 // InsertOrIncrementKeyNoLock set key into CMap or increment value of key if it exists
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use InsertOrIncrementKey on the handle given by WithShard instead.
 func (m *Uint64Map) InsertOrIncrementKeyNoLock(key string) uint64 {
 	shard := m._cmap.GetShard(key)
+	assertWriteLocked(&shard.RWMutex)
 	if val, exist := shard.items[key]; exist {
 		if iCount, okInt := val.(uint64); okInt {
 			atomic.AddUint64(&iCount, 1)
@@ -91,20 +95,33 @@ func (m *Uint64Map) InsertOrIncrementKeyNoLock(key string) uint64 {
 }
 
 // InsertOrIncrementMultiKeys list keys []string into CMap or increment value of key if it exists
-// lock shard for outer key
+// lock shards of all keys, all keys are updated atomically
 func (m *Uint64Map) InsertOrIncrementMultiKeys(keys []string) []uint64 {
 	var results []uint64
-	m.mtx.Lock()
-	defer m.mtx.Unlock()
-	for _, key := range keys {
-		result := m.InsertOrIncrementKeyNoLock(key) // lock each key
-		results = append(results, result)
-	}
+	m._cmap.Atomically(keys, func(tx Tx[string, interface{}]) error {
+		for _, key := range keys {
+			result := uint64(0)
+			if val, exist := tx.Get(key); exist {
+				if iCount, okInt := val.(uint64); okInt {
+					result = iCount + 1
+					tx.Set(key, result) // update val
+				}
+			} else { // new entry
+				result = uint64(1)
+				tx.Set(key, result)
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
 	return results
 }
 
 // InsertOrIncrementMultiKeysNoLock set list of keys <ListofKeys>
 // or increment value of innerkey if it exists
+//
+// Deprecated: nothing checks that the shards are locked, except builds with
+// the cmapdebug tag. Use InsertOrIncrementMultiKeys, which locks the shards of all keys.
 func (m *Uint64Map) InsertOrIncrementMultiKeysNoLock(keys []string) []uint64 {
 	var results []uint64
 	m.mtx.Lock()
@@ -138,8 +155,12 @@ func (m *Uint64Map) DecrementOrDeleteKey(key string) bool {
 
 // DecrementOrDeleteKeyNoLock decrement value of key by one
 // or delete one key in CMap if key count is zero
+//
+// Deprecated: nothing checks that the shard is locked, except builds with the
+// cmapdebug tag. Use DecrementOrDeleteKey on the handle given by WithShard instead.
 func (m *Uint64Map) DecrementOrDeleteKeyNoLock(key string) bool {
 	shard := m._cmap.GetShard(key)
+	assertWriteLocked(&shard.RWMutex)
 	if val, exist := shard.items[key]; exist {
 		if iCount, okInt := val.(uint64); okInt {
 			atomic.AddUint64(&iCount, ^uint64(0)) // decrement val
@@ -156,20 +177,32 @@ func (m *Uint64Map) DecrementOrDeleteKeyNoLock(key string) bool {
 
 // DecrementOrDeleteMultiKeys decrement value of keys by one
 // or delete one key in CMap if key count is zero
+// lock shards of all keys, all keys are updated atomically
 func (m *Uint64Map) DecrementOrDeleteMultiKeys(keys []string) []string {
 	var deletedKeys []string
-	m.mtx.Lock()
-	defer m.mtx.Unlock()
-	for _, key := range keys { // lock each key
-		if deleted := m.DecrementOrDeleteKeyNoLock(key); deleted {
-			deletedKeys = append(deletedKeys, key)
+	m._cmap.Atomically(keys, func(tx Tx[string, interface{}]) error {
+		for _, key := range keys {
+			if val, exist := tx.Get(key); exist {
+				if iCount, okInt := val.(uint64); okInt {
+					if iCount--; iCount == 0 {
+						tx.Delete(key)
+						deletedKeys = append(deletedKeys, key)
+					} else {
+						tx.Set(key, iCount) // update decremented key
+					}
+				}
+			}
 		}
-	}
+		return nil
+	})
 	return deletedKeys
 }
 
 // DecrementOrDeleteMultiKeysNoLock decrement value of keys by one
 // or delete one key in CMap if key count is zero
+//
+// Deprecated: nothing checks that the shards are locked, except builds with
+// the cmapdebug tag. Use DecrementOrDeleteMultiKeys, which locks the shards of all keys.
 func (m *Uint64Map) DecrementOrDeleteMultiKeysNoLock(keys []string) []string {
 	var deletedKeys []string
 	for _, key := range keys { // lock each key
@@ -179,3 +212,31 @@ func (m *Uint64Map) DecrementOrDeleteMultiKeysNoLock(keys []string) []string {
 	}
 	return deletedKeys
 }
+
+// LockedUint64Map is a handle on a locked shard of a Uint64Map, given to the
+// callbacks of WithShard. It is only valid during the callback, and only for
+// keys that belong to the locked shard.
+type LockedUint64Map struct {
+	m *Uint64Map
+	s *LockedShard[string, interface{}]
+}
+
+// WithShard write locks the shard under given key and calls fn with a handle
+// on it, see Map.WithShard.
+func (m *Uint64Map) WithShard(key string, fn func(s *LockedUint64Map)) {
+	m._cmap.WithShard(key, func(s *LockedShard[string, interface{}]) {
+		fn(&LockedUint64Map{m: m, s: s})
+	})
+}
+
+// InsertOrIncrementKey set key or increment value of key if it exists
+func (s *LockedUint64Map) InsertOrIncrementKey(key string) uint64 {
+	s.s.check(key, true)
+	return s.m.InsertOrIncrementKeyNoLock(key)
+}
+
+// DecrementOrDeleteKey decrement value of key by one, or delete key if its count is zero
+func (s *LockedUint64Map) DecrementOrDeleteKey(key string) bool {
+	s.s.check(key, true)
+	return s.m.DecrementOrDeleteKeyNoLock(key)
+}
@@ -0,0 +1,570 @@
+package cmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Write-ahead log format, version 1. All integers are little endian.
+//
+//	header:  "CWAL" | version uint16
+//	record:  payload length uint32 | crc32 (IEEE) of payload | payload
+//	payload: op byte | uvarint key length | key | op specific data
+//
+// A set carries a uvarint length prefixed value, a TTL an int64 deadline in
+// Unix nanoseconds where 0 clears the TTL, a delete nothing. A batch has no
+// key, its payload is op byte | records, the writes of one transaction, which
+// replay applies together or not at all. Compaction writes the live entries
+// to Path+".snapshot" in the same format.
+const (
+	walMagic   = "CWAL"
+	walVersion = 1
+
+	walSet    = 1
+	walDelete = 2
+	walTTL    = 3
+	walBatch  = 4
+)
+
+// SyncPolicy tells when the write-ahead log is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every record. A write is durable when it returns.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs every WALConfig.SyncInterval if anything was written.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+// ErrWAL is wrapped by the panics of writes the write-ahead log failed to
+// record, see OpenWAL.
+var ErrWAL = errors.New("cmap: write-ahead log failed")
+
+// walError wraps err, the failure to log a write, in ErrWAL.
+func walError(err error) error {
+	return fmt.Errorf("%w: %v", ErrWAL, err)
+}
+
+// panicWAL raises err, the failure to log a write.
+func panicWAL(err error) {
+	panic(walError(err))
+}
+
+// WALConfig configures OpenWAL.
+type WALConfig[K comparable, V any] struct {
+	// Path of the log file. The snapshot and the log being written during
+	// compaction live next to it, with ".snapshot" and ".next" appended.
+	Path string
+	// Codec encodes keys and values, see Codec for the defaults.
+	Codec Codec[K, V]
+	Sync  SyncPolicy
+	// SyncInterval is used with SyncInterval and defaults to one second.
+	SyncInterval time.Duration
+}
+
+// walLog appends the mutations of a map to a file. Records are written while
+// the shard lock of the key is held, so they are ordered per shard.
+type walLog[K comparable, V any] struct {
+	path     string
+	codec    Codec[K, V]
+	sync     SyncPolicy
+	compacts sync.Mutex // serializes Compact
+
+	mu     sync.Mutex
+	f      *os.File
+	buf    []byte
+	dirty  bool
+	closed bool
+	err    error // first write error, later records fail with it
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// OpenWAL creates a map with the given options and makes it durable: Set,
+// Upsert, Remove, Pop, MSet, SetWithTTL and every other mutation append a
+// record to the log at cfg.Path before returning. The snapshot and log found
+// at cfg.Path are replayed first, a torn record at the end of the log, left
+// by a crash during a write, is discarded, and entries over the capacity of
+// the map are evicted as they are replayed. Close the map to sync and close
+// the log.
+//
+// A write the log fails to record is not applied: the mutation panics with
+// an error wrapping ErrWAL once the shard lock is released. The log error
+// is kept, so every later mutation fails the same way, while reads go on.
+// With SyncInterval, a failed fsync fails the mutations after it.
+func OpenWAL[K comparable, V any](cfg WALConfig[K, V], opts ...Option) (*Map[K, V], error) {
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = time.Second
+	}
+	w := &walLog[K, V]{path: cfg.Path, codec: cfg.Codec.withDefaults(), sync: cfg.Sync}
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// The janitor writes to the map, it starts once the log is attached.
+	janitor := o.janitorInterval
+	o.janitorInterval = 0
+	m := newFromOptions[K, V](o)
+
+	if _, _, err := w.replay(m, cfg.Path+".snapshot"); err != nil {
+		return nil, err
+	}
+	good, _, err := w.replay(m, cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	_, interrupted, err := w.replay(m, cfg.Path+".next")
+	if err != nil {
+		return nil, err
+	}
+	if interrupted {
+		// A compaction did not finish, fold both logs into a new snapshot.
+		if err := w.writeSnapshot(walState(m.table.Load().shards)); err != nil { // m is not shared yet
+			return nil, err
+		}
+		if err := os.Remove(cfg.Path + ".next"); err != nil {
+			return nil, err
+		}
+		good = 0
+	}
+
+	f, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if good < int64(len(walMagic)+2) {
+		good = 0
+	}
+	if err = f.Truncate(good); err == nil {
+		if good == 0 {
+			err = writeWALHeader(f)
+		} else {
+			_, err = f.Seek(good, io.SeekStart)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.f = f
+	if w.sync == SyncInterval {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncer(cfg.SyncInterval)
+	}
+	m.wal = w
+	if janitor > 0 {
+		m.opts.janitorInterval = janitor // for Clone
+		m.startJanitor(janitor)
+	}
+	return m, nil
+}
+
+// Compact folds the log into the snapshot, so that the next OpenWAL replays
+// the live entries only. Writers are blocked while the map is copied; the
+// snapshot is written after that, while new writes go to a fresh log.
+func (m *Map[K, V]) Compact() error {
+	w := m.wal
+	if w == nil {
+		return errors.New("cmap: map has no write-ahead log")
+	}
+	w.compacts.Lock()
+	defer w.compacts.Unlock()
+
+	shards := m.pinShards()
+	for _, shard := range shards {
+		shard.RLock()
+	}
+	state := walState(shards)
+	err := w.rotate()
+	for _, shard := range shards {
+		shard.RUnlock()
+	}
+	m.unpinShards()
+	if err != nil {
+		return err
+	}
+	if err := w.writeSnapshot(state); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := os.Rename(w.path+".next", w.path); err != nil {
+		return err
+	}
+	return syncDir(w.path)
+}
+
+// WALError returns the first error met while appending to the log. Once it
+// is set, mutations panic, see OpenWAL.
+func (m *Map[K, V]) WALError() error {
+	if m.wal == nil {
+		return nil
+	}
+	m.wal.mu.Lock()
+	defer m.wal.mu.Unlock()
+	return m.wal.err
+}
+
+func (m *Map[K, V]) closeWAL() error {
+	w := m.wal
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	err := w.f.Sync()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = w.err
+	}
+	return err
+}
+
+// walEntry is the state of a key written to the snapshot.
+type walEntry[K comparable, V any] struct {
+	key      K
+	val      V
+	deadline int64
+}
+
+// walState copies the live entries of shards. Their read locks must be held.
+func walState[K comparable, V any](shards []*Shard[K, V]) []walEntry[K, V] {
+	var state []walEntry[K, V]
+	for _, shard := range shards {
+		now := shard.now()
+		for key, val := range shard.items {
+			if shard.expiredAt(key, now) {
+				continue
+			}
+			state = append(state, walEntry[K, V]{key: key, val: val, deadline: shard.expires[key]})
+		}
+	}
+	return state
+}
+
+func (w *walLog[K, V]) logSet(key K, value V) error {
+	return w.write(func(buf []byte) ([]byte, error) { return w.appendSet(buf, key, value) })
+}
+
+func (w *walLog[K, V]) logDelete(key K) error {
+	return w.write(func(buf []byte) ([]byte, error) { return w.appendDelete(buf, key) })
+}
+
+func (w *walLog[K, V]) logTTL(key K, deadline int64) error {
+	return w.write(func(buf []byte) ([]byte, error) { return w.appendTTL(buf, key, deadline) })
+}
+
+// logBatch writes records, framed by the append methods, as one batch record.
+// Shard locks of their keys must be held.
+func (w *walLog[K, V]) logBatch(records []byte) error {
+	return w.write(func(buf []byte) ([]byte, error) {
+		start := len(buf)
+		buf = append(buf, make([]byte, 8)...)
+		buf = append(buf, walBatch)
+		buf = append(buf, records...)
+		return frameWALRecord(buf, start), nil
+	})
+}
+
+func (w *walLog[K, V]) appendSet(buf []byte, key K, value V) ([]byte, error) {
+	return appendWALRecord(buf, walSet, key, w.codec.Key, func(buf []byte) ([]byte, error) {
+		return appendEncoded(buf, w.codec.Value, value)
+	})
+}
+
+func (w *walLog[K, V]) appendDelete(buf []byte, key K) ([]byte, error) {
+	return appendWALRecord(buf, walDelete, key, w.codec.Key, nil)
+}
+
+func (w *walLog[K, V]) appendTTL(buf []byte, key K, deadline int64) ([]byte, error) {
+	return appendWALRecord(buf, walTTL, key, w.codec.Key, func(buf []byte) ([]byte, error) {
+		return binary.LittleEndian.AppendUint64(buf, uint64(deadline)), nil
+	})
+}
+
+// write writes the record encode appends to its argument. Once a write
+// failed, the log may end with a partial record, so every later write fails
+// with the same error. Shard locks of the keys written must be held.
+func (w *walLog[K, V]) write(encode func([]byte) ([]byte, error)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return nil
+	}
+	buf, err := encode(w.buf[:0])
+	if err != nil {
+		// Nothing was written, the log stays usable.
+		return err
+	}
+	_, err = w.f.Write(buf)
+	if err == nil && w.sync == SyncAlways {
+		err = w.f.Sync()
+	}
+	w.buf = buf
+	w.dirty = true
+	w.err = err
+	return err
+}
+
+// appendWALRecord appends a framed record to buf.
+func appendWALRecord[K any](buf []byte, op byte, key K, keyCodec ValueCodec[K], data func([]byte) ([]byte, error)) ([]byte, error) {
+	start := len(buf)
+	buf = append(buf, make([]byte, 8)...)
+	buf = append(buf, op)
+	buf, err := appendEncoded(buf, keyCodec, key)
+	if err == nil && data != nil {
+		buf, err = data(buf)
+	}
+	if err != nil {
+		return buf[:start], err
+	}
+	return frameWALRecord(buf, start), nil
+}
+
+// frameWALRecord fills in the length and checksum of the record starting at
+// buf[start], whose payload runs to the end of buf.
+func frameWALRecord(buf []byte, start int) []byte {
+	payload := buf[start+8:]
+	binary.LittleEndian.PutUint32(buf[start:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[start+4:], crc32.ChecksumIEEE(payload))
+	return buf
+}
+
+func (w *walLog[K, V]) syncer(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty && w.err == nil {
+				w.err = w.f.Sync()
+				w.dirty = false
+			}
+			w.mu.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// rotate switches the log to Path+".next". Writers must be quiesced.
+func (w *walLog[K, V]) rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errors.New("cmap: write-ahead log is closed")
+	}
+	if w.err != nil {
+		return w.err
+	}
+	f, err := os.OpenFile(w.path+".next", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := writeWALHeader(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	w.f.Close()
+	w.f = f
+	w.dirty = false
+	return nil
+}
+
+// writeSnapshot atomically replaces Path+".snapshot" with state.
+func (w *walLog[K, V]) writeSnapshot(state []walEntry[K, V]) error {
+	tmp := w.path + ".snapshot.tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	if _, err := bw.WriteString(walMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, uint16(walVersion)); err != nil {
+		return err
+	}
+	var buf []byte
+	for _, e := range state {
+		buf, err = w.appendSet(buf[:0], e.key, e.val)
+		if err != nil {
+			return err
+		}
+		if e.deadline != 0 {
+			buf, err = w.appendTTL(buf, e.key, e.deadline)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.path+".snapshot"); err != nil {
+		return err
+	}
+	return syncDir(w.path)
+}
+
+// replay applies the records of the file at path to m, which must not be
+// shared yet. It returns the offset after the last intact record and whether
+// the file exists. Reading stops at the first torn or corrupted record.
+func (w *walLog[K, V]) replay(m *Map[K, V], path string) (int64, bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, len(walMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, true, nil // crashed while creating the file
+	}
+	if string(header[:4]) != walMagic {
+		return 0, true, fmt.Errorf("%w: %s", ErrBadFormat, path)
+	}
+	if v := binary.LittleEndian.Uint16(header[4:]); v != walVersion {
+		return 0, true, fmt.Errorf("cmap: unsupported log version %d", v)
+	}
+	offset := int64(len(header))
+	head := make([]byte, 8)
+	var payload bytes.Buffer
+	for {
+		if _, err := io.ReadFull(r, head); err != nil {
+			return offset, true, nil
+		}
+		length := int64(binary.LittleEndian.Uint32(head))
+		payload.Reset()
+		// Grow with the data read, a torn length must not allocate up front.
+		if _, err := io.CopyN(&payload, r, length); err != nil || length == 0 {
+			return offset, true, nil
+		}
+		if crc32.ChecksumIEEE(payload.Bytes()) != binary.LittleEndian.Uint32(head[4:]) {
+			return offset, true, nil
+		}
+		if err := w.apply(m, payload.Bytes()); err != nil {
+			return offset, true, err
+		}
+		offset += int64(len(head)) + length
+	}
+}
+
+// apply applies one record to m without logging it, evicting entries over
+// the capacity of m as a write would.
+func (w *walLog[K, V]) apply(m *Map[K, V], payload []byte) error {
+	op := payload[0]
+	if op == walBatch {
+		// The checksum of the batch covers its records.
+		for rest := payload[1:]; len(rest) > 0; {
+			if len(rest) < 9 || uint64(binary.LittleEndian.Uint32(rest)) > uint64(len(rest)-8) {
+				return ErrBadFormat
+			}
+			length := int(binary.LittleEndian.Uint32(rest))
+			record := rest[8 : 8+length]
+			if length == 0 || record[0] == walBatch {
+				return ErrBadFormat
+			}
+			if err := w.apply(m, record); err != nil {
+				return err
+			}
+			rest = rest[8+length:]
+		}
+		return nil
+	}
+	key, rest, err := readEncoded(payload[1:], w.codec.Key)
+	if err != nil {
+		return err
+	}
+	shard := m.lockKey(key)
+	defer m.unlock(shard)
+	shard.dirty = true
+	switch op {
+	case walSet:
+		val, rest, err := readEncoded(rest, w.codec.Value)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return ErrBadFormat
+		}
+		if shard.put(key, val) {
+			shard.evictOverflow(&key)
+		}
+	case walDelete:
+		shard.drop(key, OpRemove)
+	case walTTL:
+		if len(rest) != 8 {
+			return ErrBadFormat
+		}
+		deadline := int64(binary.LittleEndian.Uint64(rest))
+		if deadline == 0 {
+			delete(shard.expires, key)
+			break
+		}
+		if _, ok := shard.items[key]; !ok {
+			break // evicted
+		}
+		if shard.expires == nil {
+			shard.expires = make(map[K]int64)
+		}
+		shard.expires[key] = deadline
+	default:
+		return fmt.Errorf("%w: unknown log record %d", ErrBadFormat, op)
+	}
+	return nil
+}
+
+func writeWALHeader(f *os.File) error {
+	header := binary.LittleEndian.AppendUint16([]byte(walMagic), walVersion)
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// syncDir makes renames in the directory of path durable.
+func syncDir(path string) error {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,330 @@
+package cmap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func openTestWAL(t *testing.T, path string) *Map[string, int] {
+	t.Helper()
+	m, err := OpenWAL(WALConfig[string, int]{Path: path, Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestWALReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m := openTestWAL(t, path)
+	m.Set("a", 1)
+	m.MSet(map[string]int{"b": 2, "c": 3, "d": 4})
+	m.Upsert("a", 10, func(exist bool, old, v int) int { return old + v })
+	m.Remove("b")
+	m.Pop("c")
+	m.RemoveCb("d", func(string, int, bool) bool { return true })
+	m.Set("e", 5)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m = openTestWAL(t, path)
+	defer m.Close()
+	if m.Count() != 2 {
+		t.Error("replay should restore two entries, got", m.Items())
+	}
+	if v, _ := m.Get("a"); v != 11 {
+		t.Error("replay should apply the upsert.", v)
+	}
+	if v, _ := m.Get("e"); v != 5 {
+		t.Error("replay should restore e.", v)
+	}
+}
+
+func TestWALTTL(t *testing.T) {
+	now := fakeClock(t)
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m := openTestWAL(t, path)
+	m.SetWithTTL("session", 1, time.Minute)
+	m.SetWithTTL("config", 2, time.Minute)
+	m.Set("config", 3)
+	m.Close()
+
+	m = openTestWAL(t, path)
+	defer m.Close()
+	*now += int64(time.Minute)
+	if m.Has("session") {
+		t.Error("replay should restore the TTL of session.")
+	}
+	if v, ok := m.Get("config"); !ok || v != 3 {
+		t.Error("replay should restore the cleared TTL of config.")
+	}
+}
+
+func TestWALCompact(t *testing.T) {
+	now := fakeClock(t)
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m := openTestWAL(t, path)
+	for i := 0; i < 100; i++ {
+		m.Set("key", i)
+	}
+	m.SetWithTTL("session", 1, time.Minute)
+	before, _ := os.Stat(path)
+	if err := m.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Error("Compact should start a fresh log.", before.Size(), after.Size())
+	}
+	m.Set("late", 1)
+	m.Close()
+
+	m = openTestWAL(t, path)
+	defer m.Close()
+	if v, _ := m.Get("key"); v != 99 || m.Count() != 3 {
+		t.Error("replay after compaction should restore the map.", m.Items())
+	}
+	*now += int64(time.Minute)
+	if m.Has("session") {
+		t.Error("compaction should keep TTLs.")
+	}
+}
+
+func TestWALInterruptedCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m := openTestWAL(t, path)
+	m.Set("a", 1)
+	m.Close()
+	// Simulate a crash after the log was rotated.
+	if err := os.Rename(path, path+".next"); err != nil {
+		t.Fatal(err)
+	}
+	m = openTestWAL(t, path)
+	m.Set("b", 2)
+	m.Close()
+	if _, err := os.Stat(path + ".next"); !os.IsNotExist(err) {
+		t.Error("open should finish an interrupted compaction.")
+	}
+
+	m = openTestWAL(t, path)
+	defer m.Close()
+	if m.Count() != 2 {
+		t.Error("replay should restore both logs.", m.Items())
+	}
+}
+
+func TestWALTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m := openTestWAL(t, path)
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Close()
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	m = openTestWAL(t, path)
+	if m.Has("b") || !m.Has("a") {
+		t.Error("replay should drop the torn record only.", m.Items())
+	}
+	m.Set("c", 3)
+	m.Close()
+
+	m = openTestWAL(t, path)
+	defer m.Close()
+	if !m.Has("a") || !m.Has("c") {
+		t.Error("records appended after a torn tail should replay.", m.Items())
+	}
+}
+
+func TestWALAtomically(t *testing.T) {
+	now := fakeClock(t)
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m := openTestWAL(t, path)
+	m.SetWithTTL("a", 1, time.Minute)
+	m.Set("b", 2)
+	transfer := func(tx Tx[string, int]) error {
+		a, _ := tx.Get("a")
+		tx.Set("a", a-1)
+		tx.Delete("b")
+		tx.Set("c", 1)
+		return nil
+	}
+	m.Atomically([]string{"a", "b", "c"}, transfer)
+	m.Close()
+
+	m = openTestWAL(t, path)
+	*now += int64(time.Minute)
+	if v, ok := m.Get("a"); !ok || v != 0 || m.Has("b") || !m.Has("c") {
+		t.Error("replay should apply the whole transaction.", m.Items())
+	}
+	m.Close()
+
+	// Tearing the last record, the transaction, drops all of its writes.
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	m = openTestWAL(t, path)
+	defer m.Close()
+	if m.Has("a") || !m.Has("b") || m.Has("c") {
+		t.Error("replay should drop a torn transaction whole.", m.Items())
+	}
+}
+
+func TestWALSyncInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m, err := OpenWAL(WALConfig[string, int]{Path: path, Sync: SyncInterval, SyncInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set("a", 1)
+	time.Sleep(10 * time.Millisecond)
+	if err := m.WALError(); err != nil {
+		t.Error(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// expectWALPanic runs fn and fails unless it panics with ErrWAL.
+func expectWALPanic(t *testing.T, name string, fn func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		err, _ := recover().(error)
+		if !errors.Is(err, ErrWAL) {
+			t.Error(name, "should panic with ErrWAL, got", err)
+		}
+	}()
+	fn()
+}
+
+func TestWALWriteError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m := openTestWAL(t, path)
+	m.Set("a", 1)
+	// Make every later write to the log fail.
+	m.wal.f.Close()
+
+	expectWALPanic(t, "Set", func() { m.Set("b", 2) })
+	if m.Has("b") {
+		t.Error("a write the log failed to record should not be applied.")
+	}
+	expectWALPanic(t, "Remove", func() { m.Remove("a") })
+	if !m.Has("a") {
+		t.Error("a remove the log failed to record should not be applied.")
+	}
+	expectWALPanic(t, "MSet", func() {
+		m.MSet(map[string]int{"c": 3, "d": 4, "e": 5}, WithParallelism(4))
+	})
+	expectWALPanic(t, "Atomically", func() {
+		m.Atomically([]string{"a", "f"}, func(tx Tx[string, int]) error {
+			tx.Set("a", 2)
+			tx.Set("f", 6)
+			return nil
+		})
+	})
+	if m.Has("f") {
+		t.Error("a transaction the log failed to record should not be applied.")
+	}
+	if v, _ := m.Get("a"); v != 1 {
+		t.Error("reads should go on after a log failure, got", v)
+	}
+	if m.WALError() == nil {
+		t.Error("WALError should report the failure.")
+	}
+	m.Close()
+}
+
+func TestWALReplayCapacity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m := openTestWAL(t, path)
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	m.Close()
+
+	m, err := OpenWAL(WALConfig[string, int]{Path: path, Sync: SyncNever}, WithCapacity(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.Count() > 10 {
+		t.Error("replay should evict over the capacity, got", m.Count())
+	}
+	if !m.Has("99") {
+		t.Error("replay should keep the latest entries.")
+	}
+}
+
+func TestWALComputeError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m := openTestWAL(t, path)
+	defer m.Close()
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		m.GetOrCompute("a", func() (int, error) {
+			close(started)
+			<-release
+			return 1, nil
+		})
+	}()
+	<-started
+	waited := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				waited <- r.(error)
+			}
+		}()
+		_, err := m.GetOrCompute("a", func() (int, error) { return 2, nil })
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	m.wal.f.Close()
+	close(release)
+	select {
+	case err := <-waited:
+		if !errors.Is(err, ErrWAL) {
+			t.Error("the waiter should get the log error, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a waiter should not hang when the log fails.")
+	}
+}
+
+func TestWALJanitor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.wal")
+	m := openTestWAL(t, path)
+	for i := 0; i < 10000; i++ {
+		m.SetWithTTL(strconv.Itoa(i), i, time.Millisecond)
+	}
+	m.Set("kept", 1)
+	m.Close()
+	time.Sleep(2 * time.Millisecond)
+
+	m, err := OpenWAL(WALConfig[string, int]{Path: path, Sync: SyncNever}, WithJanitor(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for _, n, _ := m.shardLoad(); n != 1 && time.Now().Before(deadline); _, n, _ = m.shardLoad() {
+		time.Sleep(time.Millisecond)
+	}
+	m.Close()
+
+	m = openTestWAL(t, path)
+	defer m.Close()
+	if _, n, _ := m.shardLoad(); n != 1 {
+		t.Error("the janitor should log the entries it removes, left", n)
+	}
+}
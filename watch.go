@@ -0,0 +1,283 @@
+package cmap
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EventOp is the operation that changed a watched key.
+type EventOp uint8
+
+const (
+	OpSet    EventOp = iota + 1 // Set, MSet, SetIfAbsent, Swap and other plain writes
+	OpUpsert                    // Upsert
+	OpRemove                    // Remove, RemoveCb, CompareAndDelete
+	OpPop                       // Pop, LoadAndDelete
+	OpExpire                    // the TTL of the entry passed
+	OpEvict                     // the entry was evicted to stay within capacity
+)
+
+func (op EventOp) String() string {
+	switch op {
+	case OpSet:
+		return "set"
+	case OpUpsert:
+		return "upsert"
+	case OpRemove:
+		return "remove"
+	case OpPop:
+		return "pop"
+	case OpExpire:
+		return "expire"
+	case OpEvict:
+		return "evict"
+	}
+	return "unknown"
+}
+
+// Event describes a change of a watched key. Old is the value before the
+// change and Existed whether there was one. New is the zero value for
+// removals.
+type Event[K comparable, V any] struct {
+	Op      EventOp
+	Key     K
+	Old     V
+	New     V
+	Existed bool
+}
+
+// SlowPolicy tells what happens to events for a watcher whose buffer is full.
+type SlowPolicy int
+
+const (
+	// DropEvents discards the event and counts it, see Watcher.Dropped.
+	DropEvents SlowPolicy = iota
+	// BlockWriter makes the writer wait until the watcher takes the event,
+	// and the later writers of watched keys in the same shard behind it.
+	// Readers and writers of other keys are not held back. The watcher must
+	// not write watched keys while it is behind, or it waits for itself.
+	BlockWriter
+)
+
+// WatchOption configures a Watcher.
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	buffer int
+	slow   SlowPolicy
+}
+
+// WithBuffer sets the capacity of the event channel, 64 by default.
+func WithBuffer(n int) WatchOption {
+	return func(o *watchOptions) {
+		o.buffer = n
+	}
+}
+
+// WithSlowPolicy sets what happens to events when the channel is full,
+// DropEvents by default.
+func WithSlowPolicy(p SlowPolicy) WatchOption {
+	return func(o *watchOptions) {
+		o.slow = p
+	}
+}
+
+// Watcher receives the changes of the keys it watches on C. Events are
+// sent after the shard lock is released, in the order the changes happened
+// for keys of the same shard.
+type Watcher[K comparable, V any] struct {
+	C <-chan Event[K, V]
+
+	m       *Map[K, V]
+	ch      chan Event[K, V]
+	match   func(key K) bool
+	slow    SlowPolicy
+	dropped atomic.Uint64
+
+	mu     sync.RWMutex // held for reading while sending, see Close
+	closed bool
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Watch subscribes to the changes of key.
+func (m *Map[K, V]) Watch(key K, opts ...WatchOption) *Watcher[K, V] {
+	return m.watch(func(k K) bool { return k == key }, opts)
+}
+
+// WatchPrefix subscribes to the changes of the keys starting with prefix.
+// It panics unless K is a string type.
+func (m *Map[K, V]) WatchPrefix(prefix string, opts ...WatchOption) *Watcher[K, V] {
+	if reflect.TypeFor[K]().Kind() != reflect.String {
+		panic("cmap: WatchPrefix needs string keys")
+	}
+	return m.watch(func(k K) bool { return strings.HasPrefix(keyString(k), prefix) }, opts)
+}
+
+func (m *Map[K, V]) watch(match func(key K) bool, opts []WatchOption) *Watcher[K, V] {
+	o := watchOptions{buffer: 64}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.buffer < 0 {
+		panic("cmap: watch buffer must not be negative")
+	}
+	w := &Watcher[K, V]{
+		m:     m,
+		ch:    make(chan Event[K, V], o.buffer),
+		match: match,
+		slow:  o.slow,
+		done:  make(chan struct{}),
+	}
+	w.C = w.ch
+
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	var list []*Watcher[K, V]
+	if old := m.watchers.Load(); old != nil {
+		list = append(list, *old...)
+	}
+	list = append(list, w)
+	m.watchers.Store(&list)
+	return w
+}
+
+// Close unsubscribes the watcher and closes C. Events already in C can
+// still be received.
+func (w *Watcher[K, V]) Close() {
+	w.once.Do(func() {
+		w.m.watchMu.Lock()
+		if old := w.m.watchers.Load(); old != nil {
+			var list []*Watcher[K, V]
+			for _, o := range *old {
+				if o != w {
+					list = append(list, o)
+				}
+			}
+			if len(list) == 0 {
+				w.m.watchers.Store(nil)
+			} else {
+				w.m.watchers.Store(&list)
+			}
+		}
+		w.m.watchMu.Unlock()
+
+		close(w.done) // releases a blocked send
+		w.mu.Lock()
+		w.closed = true
+		close(w.ch)
+		w.mu.Unlock()
+	})
+}
+
+// Dropped returns how many events were discarded because C was full.
+func (w *Watcher[K, V]) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+func (w *Watcher[K, V]) send(e Event[K, V]) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return
+	}
+	if w.slow == BlockWriter {
+		select {
+		case w.ch <- e:
+		case <-w.done:
+		}
+		return
+	}
+	select {
+	case w.ch <- e:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// watched reports whether a watcher matches key, so that writes only queue
+// the events someone receives.
+func (m *Map[K, V]) watched(key K) bool {
+	list := m.watchers.Load()
+	if list == nil {
+		return false
+	}
+	for _, w := range *list {
+		if w.match(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliveryQueue orders the deliveries of the events of a shard. A writer
+// takes a turn while it holds the shard write lock and publishes once the
+// deliveries of the earlier turns are done, without holding the shard lock.
+type deliveryQueue struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	next   uint64 // next turn to take, guarded by the shard write lock
+	served uint64 // turn being delivered
+}
+
+// take returns the next turn. Shard write lock must be held.
+func (q *deliveryQueue) take() uint64 {
+	t := q.next
+	q.next++
+	return t
+}
+
+// wait blocks until it is the turn t. done must be called after.
+func (q *deliveryQueue) wait(t uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.served != t {
+		if q.cond.L == nil {
+			q.cond.L = &q.mu
+		}
+		q.cond.Wait()
+	}
+}
+
+// idle reports whether the turns taken were all delivered. Shard write lock
+// must be held.
+func (q *deliveryQueue) idle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.served == q.next
+}
+
+// done passes on to the next turn.
+func (q *deliveryQueue) done() {
+	q.mu.Lock()
+	q.served++
+	if q.cond.L != nil {
+		q.cond.Broadcast()
+	}
+	q.mu.Unlock()
+}
+
+// publish sends events to the watchers matching their keys.
+func (m *Map[K, V]) publish(events []Event[K, V]) {
+	list := m.watchers.Load()
+	if list == nil {
+		return
+	}
+	for _, e := range events {
+		for _, w := range *list {
+			if w.match(e.Key) {
+				w.send(e)
+			}
+		}
+	}
+}
+
+// keyString returns the string of a key whose type is a string type.
+func keyString[K comparable](key K) string {
+	if s, ok := any(key).(string); ok {
+		return s
+	}
+	return reflect.ValueOf(key).String()
+}
@@ -0,0 +1,198 @@
+package cmap
+
+import (
+	"testing"
+	"time"
+)
+
+func nextEvent[K comparable, V any](t *testing.T, w *Watcher[K, V]) Event[K, V] {
+	t.Helper()
+	select {
+	case e := <-w.C:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	panic("unreachable")
+}
+
+func TestWatch(t *testing.T) {
+	m := NewMap[string, int]()
+	w := m.Watch("a")
+	defer w.Close()
+
+	m.Set("b", 1)
+	m.Set("a", 1)
+	m.Upsert("a", 2, func(exist bool, old, v int) int { return old + v })
+	m.Pop("a")
+	m.Set("a", 5)
+	m.Remove("a")
+
+	want := []Event[string, int]{
+		{Op: OpSet, Key: "a", New: 1},
+		{Op: OpUpsert, Key: "a", Old: 1, New: 3, Existed: true},
+		{Op: OpPop, Key: "a", Old: 3, Existed: true},
+		{Op: OpSet, Key: "a", New: 5},
+		{Op: OpRemove, Key: "a", Old: 5, Existed: true},
+	}
+	for _, e := range want {
+		if got := nextEvent(t, w); got != e {
+			t.Errorf("got %+v, want %+v", got, e)
+		}
+	}
+	select {
+	case e := <-w.C:
+		t.Error("unexpected event", e)
+	default:
+	}
+}
+
+func TestWatchNoLock(t *testing.T) {
+	var evicted []string
+	m := NewWithOptions[string, int](WithShardCount(1), WithShardCapacity(1),
+		WithOnEvict(func(key string, v int, reason EvictReason) { evicted = append(evicted, key) }))
+	w := m.Watch("a")
+	defer w.Close()
+
+	shard := m.GetShard("a")
+	shard.Lock()
+	m.SetNoLock("a", 1)
+	m.SetNoLock("b", 2)
+	shard.Unlock()
+	if e := nextEvent(t, w); e.Op != OpSet || e.New != 1 {
+		t.Errorf("Unlock should deliver the event of SetNoLock, got %+v", e)
+	}
+	if len(evicted) != 1 || evicted[0] != "a" {
+		t.Error("Unlock should deliver the eviction of SetNoLock, got", evicted)
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	m := NewMap[string, int]()
+	w := m.WatchPrefix("config/")
+	m.Set("config/db", 1)
+	m.Set("cache/db", 1)
+	m.MSet(map[string]int{"config/log": 2})
+	if e := nextEvent(t, w); e.Key != "config/db" {
+		t.Error("WatchPrefix should report matching keys.", e)
+	}
+	if e := nextEvent(t, w); e.Key != "config/log" {
+		t.Error("WatchPrefix should report matching keys.", e)
+	}
+
+	w.Close()
+	m.Set("config/db", 2)
+	if _, ok := <-w.C; ok {
+		t.Error("Close should stop events and close C.")
+	}
+	if m.watchers.Load() != nil {
+		t.Error("Close should unsubscribe the watcher.")
+	}
+}
+
+func TestWatchExpire(t *testing.T) {
+	now := fakeClock(t)
+	m := NewMap[string, int]()
+	w := m.Watch("session")
+	defer w.Close()
+	m.SetWithTTL("session", 1, time.Second)
+	nextEvent(t, w)
+	*now += int64(time.Second)
+	m.DeleteExpired()
+	if e := nextEvent(t, w); e.Op != OpExpire || e.Old != 1 {
+		t.Error("expiry should be reported.", e)
+	}
+}
+
+func TestWatchSlowPolicy(t *testing.T) {
+	m := NewMap[string, int]()
+	drop := m.Watch("a", WithBuffer(1))
+	defer drop.Close()
+	for i := 0; i < 3; i++ {
+		m.Set("a", i)
+	}
+	if drop.Dropped() != 2 {
+		t.Error("a full watcher should drop events.", drop.Dropped())
+	}
+
+	block := m.Watch("b", WithBuffer(0), WithSlowPolicy(BlockWriter))
+	done := make(chan struct{})
+	go func() {
+		m.Set("b", 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("the writer should wait for a blocking watcher.")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// The shard lock is released while the writer waits.
+	m.Get("b")
+	if e := nextEvent(t, block); e.New != 1 {
+		t.Error(e)
+	}
+	<-done
+	block.Close()
+}
+
+func TestWatchBlockWriterSameShard(t *testing.T) {
+	m := NewWithOptions[string, int](WithShardCount(1))
+	block := m.Watch("b", WithBuffer(0), WithSlowPolicy(BlockWriter))
+	defer block.Close()
+	first := make(chan struct{})
+	go func() {
+		m.Set("b", 1)
+		close(first)
+	}()
+	second := make(chan struct{})
+	go func() {
+		<-time.After(5 * time.Millisecond)
+		m.Set("b", 2)
+		close(second)
+	}()
+
+	// Unwatched keys of the shard stay available while the watcher lags.
+	finished := make(chan struct{})
+	go func() {
+		m.Set("c", 3)
+		m.Get("c")
+		m.Remove("c")
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("a blocking watcher should not hold back other keys of the shard.")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if e := nextEvent(t, block); e.New != 1 {
+		t.Error("events should keep their order.", e)
+	}
+	if e := nextEvent(t, block); e.New != 2 {
+		t.Error("events should keep their order.", e)
+	}
+	<-first
+	<-second
+}
+
+func TestWatchCallbackMayWrite(t *testing.T) {
+	m := NewMap[string, int]()
+	w := m.Watch("a")
+	defer w.Close()
+	m.Set("a", 1)
+	e := nextEvent(t, w)
+	m.Set(e.Key, e.New+1) // must not deadlock
+	if e := nextEvent(t, w); e.New != 2 {
+		t.Error(e)
+	}
+}
+
+func TestWatchPrefixNeedsStringKeys(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("WatchPrefix should panic for int keys.")
+		}
+	}()
+	NewMap[int, int]().WatchPrefix("1")
+}